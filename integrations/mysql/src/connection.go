@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	defaultHostname = "localhost"
+	defaultPort     = 3306
+	// tlsConfigName prefixes the names the custom TLS configurations are
+	// registered with
+	tlsConfigName = "nri-mysql"
+)
+
+// generateDSN returns the DSN of the driver, which formats it so that
+// credentials with characters like @, / or ? are read back as they are.
+func generateDSN(args argumentList) string {
+	config := mysql.Config{
+		User:         args.Username,
+		Passwd:       args.Password,
+		Net:          "tcp",
+		Addr:         net.JoinHostPort(args.Hostname, strconv.Itoa(args.Port)),
+		DBName:       args.Database,
+		Timeout:      time.Duration(args.ConnectTimeout) * time.Second,
+		ReadTimeout:  time.Duration(args.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(args.WriteTimeout) * time.Second,
+	}
+	if args.Socket != "" {
+		config.Net, config.Addr = "unix", args.Socket
+	}
+
+	if customTLS(args) {
+		config.TLSConfig = tlsConfigKey(args)
+	} else if args.EnableTLS && args.TLSInsecureSkipVerify {
+		config.TLSConfig = "skip-verify"
+	} else if args.EnableTLS {
+		config.TLSConfig = "true"
+	}
+	return config.FormatDSN()
+}
+
+// customTLS tells whether the connection needs a TLS configuration other
+// than the driver's defaults, which is also the case when TLS is only
+// enabled by a CA or client certificate.
+func customTLS(args argumentList) bool {
+	return args.CABundle != "" || args.ClientCert != "" || args.ClientKey != "" || args.TLSServerName != ""
+}
+
+// tlsConfigKey returns the name of the TLS configuration of a server. Each
+// server has its own, since the driver sets the ServerName of a registered
+// configuration to the host it connects to.
+func tlsConfigKey(args argumentList) string {
+	if args.Socket != "" {
+		return tlsConfigName + ":" + args.Socket
+	}
+	return fmt.Sprintf("%s:%s:%d", tlsConfigName, args.Hostname, args.Port)
+}
+
+// registerTLSConfig registers the TLS configuration used by generateDSN
+// with the driver
+func registerTLSConfig(args argumentList) error {
+	if !customTLS(args) {
+		return nil
+	}
+
+	config := &tls.Config{
+		ServerName:         args.TLSServerName,
+		InsecureSkipVerify: args.TLSInsecureSkipVerify,
+	}
+	if args.CABundle != "" {
+		pem, err := ioutil.ReadFile(args.CABundle)
+		if err != nil {
+			return fmt.Errorf("Can't read CA bundle: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No certificates found in CA bundle %s", args.CABundle)
+		}
+		config.RootCAs = pool
+	}
+	if args.ClientCert != "" || args.ClientKey != "" {
+		if args.ClientCert == "" || args.ClientKey == "" {
+			return fmt.Errorf("Both a client certificate and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(args.ClientCert, args.ClientKey)
+		if err != nil {
+			return fmt.Errorf("Can't load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return mysql.RegisterTLSConfig(tlsConfigKey(args), config)
+}
+
+// readOptionFile returns the options of a group in a MySQL option file like
+// ~/.my.cnf, with the names normalized to use dashes.
+func readOptionFile(path string, group string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	options := make(map[string]string)
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", line[0] == '#', line[0] == ';', line[0] == '!':
+			// Comments, and !include directives which aren't followed
+			continue
+		case line[0] == '[' && line[len(line)-1] == ']':
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		case current != group:
+			continue
+		}
+
+		name, value := line, ""
+		if i := strings.Index(line, "="); i >= 0 {
+			name, value = line[:i], optionValue(strings.TrimSpace(line[i+1:]))
+		}
+		name = strings.Replace(strings.ToLower(strings.TrimSpace(name)), "_", "-", -1)
+		options[name] = value
+	}
+	return options, scanner.Err()
+}
+
+// optionValue removes the quotes or the trailing comment of a value
+func optionValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	if i := strings.Index(value, "#"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+// applyOptionFile sets the connection arguments missing in args from the
+// [client] group of args.OptionFile. The hostname and port of the file are
+// used while the arguments keep their defaults.
+func applyOptionFile(args *argumentList) error {
+	if args.OptionFile == "" {
+		return nil
+	}
+	path := args.OptionFile
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	options, err := readOptionFile(path, "client")
+	if err != nil {
+		return fmt.Errorf("Can't read option file: %s", err)
+	}
+
+	fields := map[string]*string{
+		"user":     &args.Username,
+		"password": &args.Password,
+		"socket":   &args.Socket,
+		"database": &args.Database,
+		"ssl-ca":   &args.CABundle,
+		"ssl-cert": &args.ClientCert,
+		"ssl-key":  &args.ClientKey,
+	}
+	for option, field := range fields {
+		if value, ok := options[option]; ok && *field == "" {
+			*field = value
+		}
+	}
+
+	if host, ok := options["host"]; ok && args.Hostname == defaultHostname {
+		args.Hostname = host
+	}
+	if port, ok := options["port"]; ok && args.Port == defaultPort {
+		if args.Port, err = strconv.Atoi(port); err != nil {
+			return fmt.Errorf("Invalid port %s in option file", port)
+		}
+	}
+	return nil
+}
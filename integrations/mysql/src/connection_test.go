@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateDSN(t *testing.T) {
+	base := argumentList{Hostname: "localhost", Port: 3306, Username: "dbuser", Password: "dbpwd", Database: "db"}
+	cases := []struct {
+		change func(*argumentList)
+		dsn    string
+	}{
+		{func(a *argumentList) {}, "dbuser:dbpwd@tcp(localhost:3306)/db"},
+		{func(a *argumentList) { a.Socket = "/var/run/mysqld/mysqld.sock" }, "dbuser:dbpwd@unix(/var/run/mysqld/mysqld.sock)/db"},
+		{func(a *argumentList) { a.ConnectTimeout, a.ReadTimeout, a.WriteTimeout = 5, 10, 15 }, "dbuser:dbpwd@tcp(localhost:3306)/db?readTimeout=10s&timeout=5s&writeTimeout=15s"},
+		{func(a *argumentList) { a.EnableTLS = true }, "dbuser:dbpwd@tcp(localhost:3306)/db?tls=true"},
+		{func(a *argumentList) { a.EnableTLS, a.TLSInsecureSkipVerify = true, true }, "dbuser:dbpwd@tcp(localhost:3306)/db?tls=skip-verify"},
+		{func(a *argumentList) { a.CABundle = "ca.pem" }, "dbuser:dbpwd@tcp(localhost:3306)/db?tls=nri-mysql%3Alocalhost%3A3306"},
+	}
+	for _, c := range cases {
+		arguments := base
+		c.change(&arguments)
+		if dsn := generateDSN(arguments); dsn != c.dsn {
+			t.Errorf("Expected DSN %s, got %s", c.dsn, dsn)
+		}
+	}
+}
+
+func TestGenerateDSNWithSpecialCharacters(t *testing.T) {
+	arguments := argumentList{Hostname: "::1", Port: 3306, Username: "newrelic", Password: "p@ss:w/o?rd&", Database: "db", ConnectTimeout: 5}
+	config, err := mysql.ParseDSN(generateDSN(arguments))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.User != "newrelic" || config.Passwd != "p@ss:w/o?rd&" || config.DBName != "db" {
+		t.Errorf("Unexpected credentials %s:%s and database %s", config.User, config.Passwd, config.DBName)
+	}
+	if config.Addr != "[::1]:3306" || config.Timeout != 5*time.Second {
+		t.Error()
+	}
+}
+
+func TestReadOptionFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := `# Credentials of the integration
+[mysql]
+user = other
+
+[client]
+user=newrelic
+password = "p#ss word"   # quoted
+port = 3307 # inline comment
+ssl_ca = /etc/mysql/ca.pem
+skip-ssl
+!includedir /etc/mysql/conf.d/
+; another comment
+`
+	path := filepath.Join(dir, "my.cnf")
+	if err = ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := readOptionFile(path, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"user":     "newrelic",
+		"password": "p#ss word",
+		"port":     "3307",
+		"ssl-ca":   "/etc/mysql/ca.pem",
+		"skip-ssl": "",
+	}
+	if len(options) != len(expected) {
+		t.Errorf("Expected %d options, got %d", len(expected), len(options))
+	}
+	for name, value := range expected {
+		if options[name] != value {
+			t.Errorf("For option '%s', expected value: %s. Actual value: %s", name, value, options[name])
+		}
+	}
+
+	if _, err = readOptionFile(filepath.Join(dir, "missing.cnf"), "client"); err == nil {
+		t.Error()
+	}
+}
+
+func TestApplyOptionFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "my.cnf")
+	content := "[client]\nuser = newrelic\npassword = secret\nhost = db.local\nport = 3307\nsocket = /tmp/mysql.sock\n"
+	if err = ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	arguments := argumentList{Hostname: defaultHostname, Port: defaultPort, OptionFile: path}
+	if err = applyOptionFile(&arguments); err != nil {
+		t.Fatal(err)
+	}
+	if arguments.Username != "newrelic" || arguments.Password != "secret" || arguments.Socket != "/tmp/mysql.sock" {
+		t.Error()
+	}
+	if arguments.Hostname != "db.local" || arguments.Port != 3307 {
+		t.Error()
+	}
+
+	// The arguments given take precedence
+	arguments = argumentList{Hostname: "mysql.local", Port: 3308, Username: "root", OptionFile: path}
+	if err = applyOptionFile(&arguments); err != nil {
+		t.Fatal(err)
+	}
+	if arguments.Username != "root" || arguments.Password != "secret" {
+		t.Error()
+	}
+	if arguments.Hostname != "mysql.local" || arguments.Port != 3308 {
+		t.Error()
+	}
+
+	if err = ioutil.WriteFile(path, []byte("[client]\nport = none\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	arguments = argumentList{Hostname: defaultHostname, Port: defaultPort, OptionFile: path}
+	if err = applyOptionFile(&arguments); err == nil {
+		t.Error()
+	}
+}
+
+func TestRegisterTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, "key.pem"), "EC PRIVATE KEY", keyDer)
+	if err = ioutil.WriteFile(filepath.Join(dir, "empty.pem"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []argumentList{
+		{CABundle: filepath.Join(dir, "missing.pem")},
+		{CABundle: filepath.Join(dir, "empty.pem")},
+		{ClientCert: filepath.Join(dir, "cert.pem")},
+		{ClientCert: filepath.Join(dir, "cert.pem"), ClientKey: filepath.Join(dir, "empty.pem")},
+	}
+	for _, arguments := range invalid {
+		if err = registerTLSConfig(arguments); err == nil {
+			t.Error()
+		}
+	}
+
+	arguments := argumentList{
+		Hostname:   "localhost",
+		Port:       3306,
+		CABundle:   filepath.Join(dir, "cert.pem"),
+		ClientCert: filepath.Join(dir, "cert.pem"),
+		ClientKey:  filepath.Join(dir, "key.pem"),
+	}
+	if err = registerTLSConfig(arguments); err != nil {
+		t.Fatal(err)
+	}
+	// The driver only accepts the DSN once the configuration is registered
+	config, err := mysql.ParseDSN(generateDSN(arguments))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TLSConfig != "nri-mysql:localhost:3306" {
+		t.Error()
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+	"github.com/newrelic/infra-integrations/pkg/sampler"
+)
+
+const (
+	defaultCustomQueryEventType = "MysqlCustomQuerySample"
+	// defaultCustomQueryTimeout is in seconds
+	defaultCustomQueryTimeout = 5
+)
+
+var customMetricTypes = map[string]metric.SourceType{
+	"GAUGE":     metric.GAUGE,
+	"RATE":      metric.RATE,
+	"DELTA":     metric.DELTA,
+	"ATTRIBUTE": metric.ATTRIBUTE,
+}
+
+// customMetric reports the value of a column of the results
+type customMetric struct {
+	Name       string `yaml:"name"`
+	Column     string `yaml:"column"`
+	Type       string `yaml:"type"`
+	sourceType metric.SourceType
+}
+
+// customQuery is a user-defined SQL query. Every row of its results is
+// reported as a sample with the given metrics, and the attributes columns
+// as attributes named after them.
+type customQuery struct {
+	Name       string         `yaml:"name"`
+	Query      string         `yaml:"query"`
+	EventType  string         `yaml:"event_type"`
+	Metrics    []customMetric `yaml:"metrics"`
+	Attributes []string       `yaml:"attributes"`
+	Timeout    int            `yaml:"timeout"`
+}
+
+type customQueriesConfig struct {
+	Queries []customQuery `yaml:"queries"`
+}
+
+// validate checks the definition of the n-th query and sets its defaults
+func (q *customQuery) validate(n int) error {
+	if q.Name == "" {
+		q.Name = fmt.Sprintf("query%d", n)
+	}
+	if strings.TrimSpace(q.Query) == "" {
+		return fmt.Errorf("Custom query %s has no SQL", q.Name)
+	}
+	if len(q.Metrics) == 0 {
+		return fmt.Errorf("Custom query %s has no metrics", q.Name)
+	}
+	if q.EventType == "" {
+		q.EventType = defaultCustomQueryEventType
+	}
+	if q.Timeout < 0 {
+		return fmt.Errorf("Invalid timeout %d for custom query %s", q.Timeout, q.Name)
+	} else if q.Timeout == 0 {
+		q.Timeout = defaultCustomQueryTimeout
+	}
+
+	for i := range q.Metrics {
+		m := &q.Metrics[i]
+		if m.Name == "" || m.Column == "" {
+			return fmt.Errorf("Metrics of custom query %s need a name and a column", q.Name)
+		}
+		if m.Type == "" {
+			m.Type = "GAUGE"
+		}
+		sourceType, ok := customMetricTypes[strings.ToUpper(m.Type)]
+		if !ok {
+			return fmt.Errorf("Unknown type %s of metric %s in custom query %s", m.Type, m.Name, q.Name)
+		}
+		m.sourceType = sourceType
+	}
+	return nil
+}
+
+// loadCustomQueries returns the queries of the custom_queries_config file
+// followed by the ones of the custom_queries argument.
+func loadCustomQueries() ([]customQuery, error) {
+	config := customQueriesConfig{}
+	if args.CustomQueriesConfig != "" {
+		content, err := ioutil.ReadFile(args.CustomQueriesConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read custom queries: %s", err)
+		}
+		if err = yaml.Unmarshal(content, &config); err != nil {
+			return nil, fmt.Errorf("Can't parse custom queries in %s: %s", args.CustomQueriesConfig, err)
+		}
+	}
+
+	if value := args.CustomQueries.Get(); value != nil {
+		// JSON is valid YAML, so both share the same definitions
+		content, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		var queries []customQuery
+		if err = yaml.Unmarshal(content, &queries); err != nil {
+			return nil, fmt.Errorf("Custom queries must be a JSON array of queries: %s", err)
+		}
+		config.Queries = append(config.Queries, queries...)
+	}
+
+	for i := range config.Queries {
+		if err := config.Queries[i].validate(i + 1); err != nil {
+			return nil, err
+		}
+	}
+	return config.Queries, nil
+}
+
+// populateCustomQueryMetrics runs the custom queries one after the other. A
+// query failing or taking longer than its timeout is skipped with a warning.
+// The RATE and DELTA metrics of a query are kept in a snapshot of the
+// namespace replaced every run, so the rows no longer returned are forgotten.
+func populateCustomQueryMetrics(integration *sdk.Integration, db dataSource, queries []customQuery, namespace string) {
+	for _, query := range queries {
+		rows, err := queryRowsWithTimeout(db, query.Query, time.Duration(query.Timeout)*time.Second)
+		if err != nil {
+			log.Warn("Custom query %s failed: %s", query.Name, err)
+			continue
+		}
+		snapshot := sampler.LoadSnapshot(sampler.Key(namespace, "custom/"+query.Name))
+		for _, r := range rows {
+			sample := integration.NewMetricSet(query.EventType)
+			populateCustomQuerySample(sample, query, r, snapshot)
+		}
+		if err = snapshot.Save(); err != nil {
+			log.Warn("Can't save the snapshot of custom query %s: %s", query.Name, err)
+		}
+	}
+}
+
+func populateCustomQuerySample(sample *metric.MetricSet, query customQuery, r row, snapshot *sampler.Snapshot) {
+	sample.SetMetric("query.name", query.Name, metric.ATTRIBUTE)
+
+	// The attributes tell apart the rows sampled in the snapshot
+	key := ""
+	for _, column := range query.Attributes {
+		value, ok := r.getString(column)
+		if !ok {
+			log.Warn("Can't find column %s in results of custom query %s", column, query.Name)
+			continue
+		}
+		sample.SetMetric(column, value, metric.ATTRIBUTE)
+		key += "/" + value
+	}
+
+	for _, m := range query.Metrics {
+		value, ok := r[m.Column]
+		if !ok {
+			log.Warn("Can't find column %s in results of custom query %s", m.Column, query.Name)
+			continue
+		}
+
+		sourceType := m.sourceType
+		switch sourceType {
+		case metric.RATE, metric.DELTA:
+			sampled, err := snapshot.Sample(key+"/"+m.Name, value, sourceType)
+			if err != nil {
+				log.Warn("Error setting value: %s", err)
+				continue
+			}
+			value, sourceType = sampled, metric.GAUGE
+		case metric.ATTRIBUTE:
+			value = fmt.Sprintf("%v", value)
+		}
+		if err := sample.SetMetric(m.Name, value, sourceType); err != nil {
+			log.Warn("%s", err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sdk_args "github.com/newrelic/infra-integrations-sdk/args"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+	"github.com/newrelic/infra-integrations/pkg/sampler"
+)
+
+var testCustomQueries = `
+queries:
+  - name: jobs
+    query: SELECT queue, COUNT(*) AS depth, SUM(done) AS done, MAX(status) AS status FROM jobs GROUP BY queue
+    event_type: MysqlJobQueueSample
+    attributes: [queue]
+    metrics:
+      - name: queue.depth
+        column: depth
+      - name: queue.donePerSecond
+        column: done
+        type: rate
+      - name: queue.status
+        column: status
+        type: ATTRIBUTE
+  - query: SELECT TIMESTAMPDIFF(SECOND, MIN(created), NOW()) AS age FROM jobs
+    timeout: 1
+    metrics:
+      - name: queue.oldestJobAgeSeconds
+        column: age
+`
+
+// slowDataSource takes delay to answer every query, unless interrupted
+type slowDataSource struct {
+	fakeDataSource
+	delay       time.Duration
+	interrupted *bool
+}
+
+func (d slowDataSource) queryRows(query string) ([]row, error) {
+	return d.queryRowsContext(context.Background(), query)
+}
+
+func (d slowDataSource) queryRowsContext(ctx context.Context, query string) ([]row, error) {
+	select {
+	case <-time.After(d.delay):
+		return d.fakeDataSource.queryRows(query)
+	case <-ctx.Done():
+		*d.interrupted = true
+		return nil, errors.New("Error 1317: Query execution was interrupted")
+	}
+}
+
+func TestLoadCustomQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queries.yml")
+	if err = ioutil.WriteFile(path, []byte(testCustomQueries), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args = argumentList{CustomQueriesConfig: path}
+	args.CustomQueries.Set(`[{"name": "users", "query": "SELECT COUNT(*) AS users FROM users", "metrics": [{"name": "app.users", "column": "users"}]}]`)
+	defer func() { args = argumentList{} }()
+
+	queries, err := loadCustomQueries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 3 {
+		t.Fatal()
+	}
+	if queries[0].EventType != "MysqlJobQueueSample" || queries[0].Timeout != defaultCustomQueryTimeout {
+		t.Error()
+	}
+	if queries[0].Metrics[0].sourceType != metric.GAUGE || queries[0].Metrics[1].sourceType != metric.RATE {
+		t.Error()
+	}
+	if queries[1].Name != "query2" || queries[1].EventType != defaultCustomQueryEventType || queries[1].Timeout != 1 {
+		t.Error()
+	}
+	if queries[2].Name != "users" || queries[2].Metrics[0].Column != "users" {
+		t.Error()
+	}
+}
+
+func TestLoadBadCustomQueries(t *testing.T) {
+	defer func() { args = argumentList{} }()
+
+	bad := []string{
+		`[{"name": "nosql", "metrics": [{"name": "a", "column": "a"}]}]`,
+		`[{"query": "SELECT 1 AS a"}]`,
+		`[{"query": "SELECT 1 AS a", "metrics": [{"name": "a"}]}]`,
+		`[{"query": "SELECT 1 AS a", "metrics": [{"name": "a", "column": "a", "type": "COUNTER"}]}]`,
+		`[{"query": "SELECT 1 AS a", "timeout": -1, "metrics": [{"name": "a", "column": "a"}]}]`,
+		`{"query": "SELECT 1 AS a"}`,
+	}
+	for _, queries := range bad {
+		args = argumentList{CustomQueries: *sdk_args.NewJSON(nil)}
+		args.CustomQueries.Set(queries)
+		if _, err := loadCustomQueries(); err == nil {
+			t.Errorf("Expected an error for %s", queries)
+		}
+	}
+
+	args = argumentList{CustomQueriesConfig: "/nonexistent/queries.yml"}
+	if _, err := loadCustomQueries(); err == nil {
+		t.Error()
+	}
+}
+
+func TestPopulateCustomQueryMetrics(t *testing.T) {
+	defer useTempSnapshots(t)()
+	queries := []customQuery{
+		{
+			Name:       "jobs",
+			Query:      "SELECT queue, depth, done FROM jobs",
+			Attributes: []string{"queue"},
+			Metrics: []customMetric{
+				{Name: "queue.depth", Column: "depth", Type: "GAUGE"},
+				{Name: "queue.donePerSecond", Column: "done", Type: "RATE"},
+			},
+		},
+		{
+			Name:    "age",
+			Query:   "SELECT age FROM jobs",
+			Metrics: []customMetric{{Name: "queue.oldestJobAgeSeconds", Column: "age"}},
+		},
+	}
+	for i := range queries {
+		if err := queries[i].validate(i + 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := fakeDataSource{
+		rows: map[string][]row{
+			queries[0].Query: {
+				{"queue": "mail", "depth": 12, "done": 100},
+				{"queue": "billing", "depth": 3, "done": 50},
+			},
+			queries[1].Query: {{"age": 360}},
+		},
+	}
+
+	now := time.Now()
+	sampler.SetNow(func() time.Time { return now })
+	defer sampler.SetNow(time.Now)
+
+	integration := &sdk.Integration{}
+	populateCustomQueryMetrics(integration, db, queries, "")
+	if len(integration.Metrics) != 3 {
+		t.Fatal()
+	}
+	mail := *integration.Metrics[0]
+	if mail["event_type"] != defaultCustomQueryEventType || mail["query.name"] != "jobs" || mail["queue"] != "mail" {
+		t.Error()
+	}
+	if mail["queue.depth"] != 12 || mail["queue.donePerSecond"] != float64(0) {
+		t.Error()
+	}
+	if (*integration.Metrics[2])["queue.oldestJobAgeSeconds"] != 360 {
+		t.Error()
+	}
+
+	// Every row has its own rate
+	db.rows[queries[0].Query] = []row{
+		{"queue": "mail", "depth": 10, "done": 130},
+		{"queue": "billing", "depth": 3, "done": 60},
+	}
+	now = now.Add(10 * time.Second)
+	integration = &sdk.Integration{}
+	populateCustomQueryMetrics(integration, db, queries, "")
+	if (*integration.Metrics[0])["queue.donePerSecond"] != float64(3) || (*integration.Metrics[1])["queue.donePerSecond"] != float64(1) {
+		t.Error()
+	}
+
+	// The rows not returned by a run are forgotten
+	db.rows[queries[0].Query] = []row{{"queue": "mail", "depth": 10, "done": 150}}
+	now = now.Add(10 * time.Second)
+	populateCustomQueryMetrics(&sdk.Integration{}, db, queries, "")
+	db.rows[queries[0].Query] = []row{{"queue": "billing", "depth": 3, "done": 80}}
+	now = now.Add(10 * time.Second)
+	integration = &sdk.Integration{}
+	populateCustomQueryMetrics(integration, db, queries, "")
+	if (*integration.Metrics[0])["queue.donePerSecond"] != float64(0) {
+		t.Error()
+	}
+}
+
+func TestQueryRowsWithTimeout(t *testing.T) {
+	query := "SELECT SLEEP(1) AS slept"
+	interrupted := false
+	db := slowDataSource{
+		fakeDataSource{rows: map[string][]row{query: {{"slept": 0}}}},
+		200 * time.Millisecond,
+		&interrupted,
+	}
+	if _, err := queryRowsWithTimeout(db, query, 10*time.Millisecond); err == nil || !interrupted {
+		t.Error()
+	}
+	interrupted = false
+
+	rows, err := queryRowsWithTimeout(db, query, 5*time.Second)
+	if err != nil || len(rows) != 1 || interrupted {
+		t.Error()
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/newrelic/infra-integrations-sdk/log"
 )
 
 type dataSource interface {
 	close()
 	query(string) (map[string]interface{}, error)
+	queryRows(string) ([]row, error)
+	queryRowsContext(context.Context, string) ([]row, error)
 }
 
 type database struct {
@@ -48,3 +55,147 @@ func (db *database) query(query string) (map[string]interface{}, error) {
 	return rawData, nil
 
 }
+
+// row maps the column names of a result row to their values, converted to
+// int, float64 or string. NULL columns are left out.
+type row map[string]interface{}
+
+// getString returns the value of a column as a string
+func (r row) getString(column string) (string, bool) {
+	value, ok := r[column]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%v", value), true
+}
+
+// getInt returns the value of an integer column
+func (r row) getInt(column string) (int, bool) {
+	value, ok := r[column].(int)
+	return value, ok
+}
+
+// getFloat returns the value of a numeric column
+func (r row) getFloat(column string) (float64, bool) {
+	switch value := r[column].(type) {
+	case int:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+// asColumnValue converts a value scanned from a result row. Unlike asValue,
+// text columns are never taken as booleans.
+func asColumnValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		s := string(v)
+		if i, err := strconv.Atoi(s); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+		return s
+	case int64:
+		return int(v)
+	case float32:
+		return float64(v)
+	}
+	return value
+}
+
+// queryRows returns every row of the results, for queries with any number of
+// columns.
+func (db *database) queryRows(query string) ([]row, error) {
+	rows, err := db.source.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows(rows)
+}
+
+// queryRowsContext works like queryRows, but the query is interrupted in the
+// server when ctx is done. The driver doesn't support contexts, so it runs
+// on a connection of its own that is sent a KILL QUERY.
+func (db *database) queryRowsContext(ctx context.Context, query string) ([]row, error) {
+	conn, err := db.source.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var id int
+	if err = conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&id); err != nil {
+		return nil, err
+	}
+
+	// The connection goes back to the pool only once the killer is done, so
+	// that it can't interrupt a later query
+	finished := make(chan struct{})
+	killed := make(chan struct{})
+	go func() {
+		defer close(killed)
+		select {
+		case <-ctx.Done():
+			if _, err := db.source.Exec(fmt.Sprintf("KILL QUERY %d", id)); err != nil {
+				log.Warn("Can't interrupt query on connection %d: %s", id, err)
+			}
+		case <-finished:
+		}
+	}()
+	defer func() {
+		close(finished)
+		<-killed
+	}()
+
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows(rows)
+}
+
+// scanRows reads and closes the rows of a result
+func scanRows(rows *sql.Rows) ([]row, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	result := make([]row, 0)
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		r := make(row)
+		for i, column := range columns {
+			if values[i] != nil {
+				r[column] = asColumnValue(values[i])
+			}
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// queryRowsWithTimeout interrupts a query that takes longer than timeout.
+func queryRowsWithTimeout(db dataSource, query string, timeout time.Duration) ([]row, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rows, err := db.queryRowsContext(ctx, query)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("Query timed out after %s", timeout)
+	}
+	return rows, err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// fakeDataSource answers every query with the rows, or the error, registered
+// for it. Queries with nothing registered return an empty result.
+type fakeDataSource struct {
+	rows   map[string][]row
+	errors map[string]error
+}
+
+func (d fakeDataSource) close() {}
+
+// query reads two-column results like SHOW STATUS from the Variable_name and
+// Value columns of the rows.
+func (d fakeDataSource) query(query string) (map[string]interface{}, error) {
+	rows, err := d.queryRows(query)
+	if err != nil {
+		return nil, err
+	}
+	rawData := make(map[string]interface{})
+	for _, r := range rows {
+		name, _ := r.getString("Variable_name")
+		rawData[name] = r["Value"]
+	}
+	return rawData, nil
+}
+
+func (d fakeDataSource) queryRowsContext(ctx context.Context, query string) ([]row, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return d.queryRows(query)
+}
+
+func (d fakeDataSource) queryRows(query string) ([]row, error) {
+	if err, ok := d.errors[query]; ok {
+		return nil, err
+	}
+	// Every query returns new rows, as a database would
+	result := make([]row, 0, len(d.rows[query]))
+	for _, r := range d.rows[query] {
+		clone := make(row, len(r))
+		for column, value := range r {
+			clone[column] = value
+		}
+		result = append(result, clone)
+	}
+	return result, nil
+}
+
+// variableRows builds the result of SHOW VARIABLES or SHOW STATUS
+func variableRows(values map[string]interface{}) []row {
+	rows := make([]row, 0, len(values))
+	for name, value := range values {
+		rows = append(rows, row{"Variable_name": name, "Value": value})
+	}
+	return rows
+}
+
+func TestAsColumnValue(t *testing.T) {
+	if asColumnValue([]byte("10")) != 10 {
+		t.Error()
+	}
+	if asColumnValue([]byte("0.12")) != 0.12 {
+		t.Error()
+	}
+	// Unlike asValue, "1"-like text isn't a boolean
+	if asColumnValue([]byte("true")) != "true" || asColumnValue([]byte("T")) != "T" {
+		t.Error()
+	}
+	if asColumnValue(int64(3)) != 3 || asColumnValue(float32(0.5)) != 0.5 {
+		t.Error()
+	}
+}
+
+func TestRowGetters(t *testing.T) {
+	r := row{"name": "orders", "rows": 10, "ratio": 0.5}
+
+	if s, ok := r.getString("name"); !ok || s != "orders" {
+		t.Error()
+	}
+	if s, ok := r.getString("rows"); !ok || s != "10" {
+		t.Error()
+	}
+	if i, ok := r.getInt("rows"); !ok || i != 10 {
+		t.Error()
+	}
+	if _, ok := r.getInt("ratio"); ok {
+		t.Error()
+	}
+	if f, ok := r.getFloat("rows"); !ok || f != 10 {
+		t.Error()
+	}
+	if f, ok := r.getFloat("ratio"); !ok || f != 0.5 {
+		t.Error()
+	}
+	if _, ok := r.getFloat("name"); ok {
+		t.Error()
+	}
+	if _, ok := r.getString("missing"); ok {
+		t.Error()
+	}
+}
+
+func TestFakeDataSource(t *testing.T) {
+	db := fakeDataSource{
+		rows: map[string][]row{
+			metricsQuery: variableRows(map[string]interface{}{"Queries": 10}),
+			replicaQuery: {{"Channel_Name": "orders"}},
+		},
+		errors: map[string]error{inventoryQuery: fmt.Errorf("Access denied")},
+	}
+
+	metrics, err := db.query(metricsQuery)
+	if err != nil || metrics["Queries"] != 10 {
+		t.Error()
+	}
+	if _, err = db.query(inventoryQuery); err == nil {
+		t.Error()
+	}
+
+	rows, err := db.queryRows(replicaQuery)
+	if err != nil || len(rows) != 1 {
+		t.Fatal()
+	}
+	rows[0]["Channel_Name"] = "billing"
+	if rows, _ = db.queryRows(replicaQuery); rows[0]["Channel_Name"] != "orders" {
+		t.Error()
+	}
+	if rows, err = db.queryRows("SELECT 1"); err != nil || len(rows) != 0 {
+		t.Error()
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+	"github.com/newrelic/infra-integrations/pkg/sampler"
+)
+
+const digestsQuery = `SELECT SCHEMA_NAME, DIGEST, DIGEST_TEXT, COUNT_STAR, SUM_TIMER_WAIT,
+SUM_ROWS_EXAMINED, SUM_NO_INDEX_USED, SUM_NO_GOOD_INDEX_USED
+FROM performance_schema.events_statements_summary_by_digest`
+
+// digestsQueryTimeout bounds the time spent reading the digests summary
+const digestsQueryTimeout = 10 * time.Second
+
+// picosecondsPerSecond converts the performance_schema timers to seconds
+const picosecondsPerSecond = 1e12
+
+var digestMetrics = map[string][]interface{}{
+	"digest.schema":               {"schema", metric.ATTRIBUTE},
+	"digest.id":                   {"digest", metric.ATTRIBUTE},
+	"digest.text":                 {"text", metric.ATTRIBUTE},
+	"digest.rank":                 {"rank", metric.GAUGE},
+	"digest.execCount":            {"COUNT_STAR", metric.GAUGE},
+	"digest.totalLatencySeconds":  {digestTotalLatency, metric.GAUGE},
+	"digest.avgLatencySeconds":    {digestAvgLatency, metric.GAUGE},
+	"digest.rowsExamined":         {"SUM_ROWS_EXAMINED", metric.GAUGE},
+	"digest.noIndexUsedCount":     {"SUM_NO_INDEX_USED", metric.GAUGE},
+	"digest.noGoodIndexUsedCount": {"SUM_NO_GOOD_INDEX_USED", metric.GAUGE},
+}
+
+// digestCounters are the cumulative columns turned into deltas
+var digestCounters = []string{"COUNT_STAR", "SUM_TIMER_WAIT", "SUM_ROWS_EXAMINED", "SUM_NO_INDEX_USED", "SUM_NO_GOOD_INDEX_USED"}
+
+func digestTotalLatency(metrics map[string]interface{}) (float64, bool) {
+	latency, ok := metrics["SUM_TIMER_WAIT"].(float64)
+	return latency / picosecondsPerSecond, ok
+}
+
+func digestAvgLatency(metrics map[string]interface{}) (float64, bool) {
+	latency, ok1 := metrics["SUM_TIMER_WAIT"].(float64)
+	count, ok2 := metrics["COUNT_STAR"].(float64)
+	if count == 0 {
+		return 0, ok1 && ok2
+	}
+	return latency / count / picosecondsPerSecond, ok1 && ok2
+}
+
+// digestDeltas replaces the counters of a digest with their change since the
+// previous run, kept in snapshot. Digests not seen before have no
+// executions, and it's false when the summary was reset by a TRUNCATE.
+func digestDeltas(digest row, snapshot *sampler.Snapshot) bool {
+	schema, _ := digest.getString("SCHEMA_NAME")
+	id, _ := digest.getString("DIGEST")
+	text, _ := digest.getString("DIGEST_TEXT")
+	digest["schema"], digest["digest"], digest["text"] = schema, id, queryText(text)
+
+	key := schema + "/" + id
+	ok := true
+	// Every counter is sampled, even after a failure, to keep the cache current
+	for _, counter := range digestCounters {
+		value, found := digest.getFloat(counter)
+		if !found {
+			ok = false
+			continue
+		}
+		delta, err := snapshot.Sample(key+"/"+counter, value, metric.DELTA)
+		if err != nil {
+			log.Debug("Skipping digest %s: %s", id, err)
+			ok = false
+		}
+		digest[counter] = delta
+	}
+	return ok
+}
+
+// populateDigestMetrics reports a MysqlQueryDigestSample for the statement
+// digests with the highest latency since the previous run, up to limit unless
+// it's 0. The counters are kept in a snapshot of the namespace replaced every
+// run, so the digests evicted from the summary are forgotten.
+func populateDigestMetrics(integration *sdk.Integration, db dataSource, limit int, namespace string) error {
+	if limit < 0 {
+		return fmt.Errorf("Invalid digest metrics limit %d", limit)
+	}
+	digests, err := queryRowsWithTimeout(db, digestsQuery, digestsQueryTimeout)
+	if err != nil {
+		return err
+	}
+
+	snapshot := sampler.LoadSnapshot(sampler.Key(namespace, "digests"))
+	active := make([]row, 0)
+	for _, digest := range digests {
+		if digestDeltas(digest, snapshot) && digest["COUNT_STAR"].(float64) > 0 {
+			active = append(active, digest)
+		}
+	}
+	if err = snapshot.Save(); err != nil {
+		log.Warn("Can't save the statement digests snapshot: %s", err)
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		return active[i]["SUM_TIMER_WAIT"].(float64) > active[j]["SUM_TIMER_WAIT"].(float64)
+	})
+
+	for i, digest := range active {
+		if limit > 0 && i >= limit {
+			break
+		}
+		digest["rank"] = i + 1
+		sample := integration.NewMetricSet("MysqlQueryDigestSample")
+		populatePartialMetrics(sample, digest, digestMetrics)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+	"github.com/newrelic/infra-integrations/pkg/sampler"
+)
+
+// useTempSnapshots keeps the sampler snapshots of a test in a directory of
+// its own, so that they don't depend on previous test runs
+func useTempSnapshots(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("NRIA_CACHE_PATH")
+	os.Setenv("NRIA_CACHE_PATH", filepath.Join(dir, "cache.json"))
+	return func() {
+		os.Setenv("NRIA_CACHE_PATH", oldPath)
+		os.RemoveAll(dir)
+	}
+}
+
+func testDigest(schema, digest string, count, timerWait, rowsExamined, noIndexUsed int) row {
+	return row{
+		"SCHEMA_NAME":            schema,
+		"DIGEST":                 digest,
+		"DIGEST_TEXT":            "SELECT * FROM `" + digest + "` WHERE `id` = ?",
+		"COUNT_STAR":             count,
+		"SUM_TIMER_WAIT":         timerWait,
+		"SUM_ROWS_EXAMINED":      rowsExamined,
+		"SUM_NO_INDEX_USED":      noIndexUsed,
+		"SUM_NO_GOOD_INDEX_USED": 0,
+	}
+}
+
+func TestPopulateDigestMetrics(t *testing.T) {
+	defer useTempSnapshots(t)()
+	db := fakeDataSource{
+		rows: map[string][]row{digestsQuery: {
+			testDigest("app", "orders", 100, 1000000000000, 1000, 0),
+			testDigest("app", "users", 10, 5000000000000, 100, 10),
+			testDigest("crm", "orders", 1, 1000000000, 1, 0),
+		}},
+	}
+
+	now := time.Now()
+	sampler.SetNow(func() time.Time { return now })
+	defer sampler.SetNow(time.Now)
+
+	// The first run only takes the snapshot
+	integration := &sdk.Integration{}
+	if err := populateDigestMetrics(integration, db, 2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(integration.Metrics) != 0 {
+		t.Fatal()
+	}
+
+	db.rows[digestsQuery] = []row{
+		testDigest("app", "orders", 300, 9000000000000, 3000, 0),
+		testDigest("app", "users", 12, 7000000000000, 120, 12),
+		// Reset by a TRUNCATE
+		testDigest("crm", "orders", 0, 0, 0, 0),
+		testDigest("crm", "users", 1, 1000000000, 1, 0),
+	}
+	now = now.Add(time.Minute)
+	integration = &sdk.Integration{}
+	if err := populateDigestMetrics(integration, db, 2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(integration.Metrics) != 2 {
+		t.Fatal()
+	}
+
+	orders := *integration.Metrics[0]
+	if orders["event_type"] != "MysqlQueryDigestSample" || orders["digest.rank"] != 1 {
+		t.Error()
+	}
+	if orders["digest.schema"] != "app" || orders["digest.id"] != "orders" || orders["digest.text"] != "SELECT * FROM `orders` WHERE `id` = ?" {
+		t.Error()
+	}
+	if orders["digest.execCount"] != float64(200) || orders["digest.totalLatencySeconds"] != float64(8) || orders["digest.avgLatencySeconds"] != 0.04 {
+		t.Error()
+	}
+	if orders["digest.rowsExamined"] != float64(2000) || orders["digest.noIndexUsedCount"] != float64(0) {
+		t.Error()
+	}
+
+	users := *integration.Metrics[1]
+	if users["digest.id"] != "users" || users["digest.rank"] != 2 || users["digest.noIndexUsedCount"] != float64(2) {
+		t.Error()
+	}
+}
+
+func TestPopulateDigestMetricsWithoutLimit(t *testing.T) {
+	defer useTempSnapshots(t)()
+	args = argumentList{QueryTextLength: 20}
+	defer func() { args = argumentList{} }()
+
+	long := testDigest("app", "orders", 1, 1000000000, 1, 0)
+	long["DIGEST_TEXT"] = "SELECT * FROM `orders` WHERE `id` IN (" + strings.Repeat("?, ", 1000) + "?)"
+	db := fakeDataSource{
+		rows: map[string][]row{digestsQuery: {long, testDigest("app", "users", 1, 1000000000, 1, 0)}},
+	}
+
+	now := time.Now()
+	sampler.SetNow(func() time.Time { return now })
+	defer sampler.SetNow(time.Now)
+
+	if err := populateDigestMetrics(&sdk.Integration{}, db, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	db.rows[digestsQuery] = []row{testDigest("app", "orders", 2, 2000000000, 2, 0), testDigest("app", "users", 2, 2000000000, 2, 0)}
+	db.rows[digestsQuery][0]["DIGEST_TEXT"] = long["DIGEST_TEXT"]
+	now = now.Add(time.Minute)
+	integration := &sdk.Integration{}
+	if err := populateDigestMetrics(integration, db, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(integration.Metrics) != 2 {
+		t.Fatalf("Expected every digest, got %d", len(integration.Metrics))
+	}
+	if text := (*integration.Metrics[0])["digest.text"]; text != "SELECT * FROM `order..." {
+		t.Errorf("Unexpected text %q", text)
+	}
+
+	if err := populateDigestMetrics(&sdk.Integration{}, db, -1, ""); err == nil {
+		t.Error()
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/metric"
+)
+
+var galeraMetrics = map[string][]interface{}{
+	"galera.clusterSize":               {"wsrep_cluster_size", metric.GAUGE},
+	"galera.clusterStatus":             {"wsrep_cluster_status", metric.ATTRIBUTE},
+	"galera.localState":                {"wsrep_local_state_comment", metric.ATTRIBUTE},
+	"galera.flowControlPausedFraction": {"wsrep_flow_control_paused", metric.GAUGE},
+	"galera.localRecvQueueAvg":         {"wsrep_local_recv_queue_avg", metric.GAUGE},
+	"galera.localSendQueueAvg":         {"wsrep_local_send_queue_avg", metric.GAUGE},
+	"galera.certFailuresPerSecond":     {"wsrep_local_cert_failures", metric.RATE},
+	"galera.ready":                     {switchOn("wsrep_ready"), metric.GAUGE},
+	"galera.connected":                 {switchOn("wsrep_connected"), metric.GAUGE},
+}
+
+// switchOn returns a source of 1 when the status variable is ON and 0 otherwise
+func switchOn(name string) func(map[string]interface{}) (float64, bool) {
+	return func(metrics map[string]interface{}) (float64, bool) {
+		value, ok := metrics[name]
+		if !ok {
+			return 0, false
+		}
+		if strings.EqualFold(fmt.Sprintf("%v", value), "ON") {
+			return 1, true
+		}
+		return 0, true
+	}
+}
+
+// isGalera tells whether the server is a Galera node, like the ones of
+// Percona XtraDB Cluster and MariaDB Galera Cluster. Those servers have the
+// wsrep_* status variables even when replication is disabled, in which case
+// there is no provider.
+func isGalera(metrics map[string]interface{}) bool {
+	provider, ok := metrics["wsrep_provider_name"]
+	return ok && fmt.Sprintf("%v", provider) != ""
+}
+
+// galeraRole returns the node type of a Galera node after the status of the
+// cluster component it belongs to: "galera-primary", "galera-non-primary" or
+// "galera-disconnected".
+func galeraRole(metrics map[string]interface{}) string {
+	status, ok := metrics["wsrep_cluster_status"]
+	if !ok {
+		return "galera-unknown"
+	}
+	return "galera-" + strings.ToLower(fmt.Sprintf("%v", status))
+}
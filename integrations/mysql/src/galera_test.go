@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/metric"
+)
+
+func galeraStatus(clusterStatus string) map[string]interface{} {
+	return map[string]interface{}{
+		"wsrep_provider_name":        "Galera",
+		"wsrep_cluster_size":         3,
+		"wsrep_cluster_status":       clusterStatus,
+		"wsrep_local_state_comment":  "Synced",
+		"wsrep_flow_control_paused":  0.25,
+		"wsrep_local_recv_queue_avg": 1.5,
+		"wsrep_local_send_queue_avg": 0,
+		"wsrep_local_cert_failures":  12,
+		"wsrep_ready":                "ON",
+		"wsrep_connected":            "OFF",
+	}
+}
+
+func TestGaleraRole(t *testing.T) {
+	if isGalera(map[string]interface{}{"Queries": 10}) {
+		t.Error()
+	}
+	// wsrep_provider=none
+	if isGalera(map[string]interface{}{"wsrep_provider_name": "", "wsrep_cluster_status": "Disconnected"}) {
+		t.Error()
+	}
+
+	roles := map[string]string{
+		"Primary":      "galera-primary",
+		"non-Primary":  "galera-non-primary",
+		"Disconnected": "galera-disconnected",
+	}
+	for status, role := range roles {
+		metrics := galeraStatus(status)
+		if !isGalera(metrics) || galeraRole(metrics) != role {
+			t.Errorf("Expected role %s for status %s, got %s", role, status, galeraRole(metrics))
+		}
+	}
+}
+
+func TestGetRawDataGalera(t *testing.T) {
+	database := fakeDataSource{
+		rows: map[string][]row{
+			metricsQuery: variableRows(galeraStatus("Primary")),
+			replicaQuery: {{"Slave_IO_Running": "Yes"}},
+		},
+	}
+	_, metrics, err := getRawData(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := metrics["replicas"].([]row); metrics["node_type"] != "galera-primary" || !ok {
+		t.Error()
+	}
+}
+
+func TestPopulateGaleraMetrics(t *testing.T) {
+	sample := metric.NewMetricSet("MysqlSample")
+	populatePartialMetrics(&sample, galeraStatus("Primary"), galeraMetrics)
+
+	expected := map[string]interface{}{
+		"galera.clusterSize":               3,
+		"galera.clusterStatus":             "Primary",
+		"galera.localState":                "Synced",
+		"galera.flowControlPausedFraction": 0.25,
+		"galera.localRecvQueueAvg":         1.5,
+		"galera.localSendQueueAvg":         0,
+		"galera.ready":                     float64(1),
+		"galera.connected":                 float64(0),
+	}
+	for name, value := range expected {
+		if sample[name] != value {
+			t.Errorf("For metric '%s', expected value: %v. Actual value: %v", name, value, sample[name])
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/metric"
+)
+
+const innodbStatusQuery = "SHOW ENGINE INNODB STATUS"
+
+var innodbStatusMetrics = map[string][]interface{}{
+	"db.innodb.historyListLength":         {"history_list_length", metric.GAUGE},
+	"db.innodb.pendingReads":              {"pending_reads", metric.GAUGE},
+	"db.innodb.pendingWrites":             {"pending_writes", metric.GAUGE},
+	"db.innodb.pendingLogFlushes":         {"pending_log_flushes", metric.GAUGE},
+	"db.innodb.pendingBufferPoolFlushes":  {"pending_buffer_pool_flushes", metric.GAUGE},
+	"db.innodb.logSequenceNumber":         {"log_sequence_number", metric.GAUGE},
+	"db.innodb.lastCheckpoint":            {"last_checkpoint", metric.GAUGE},
+	"db.innodb.checkpointAgeBytes":        {checkpointAge, metric.GAUGE},
+	"db.innodb.semaphoreWaits":            {"semaphore_waits", metric.GAUGE},
+	"db.innodb.semaphoreMaxWaitSeconds":   {"semaphore_max_wait", metric.GAUGE},
+	"db.innodb.longTransactions":          {"long_transactions", metric.GAUGE},
+	"db.innodb.longestTransactionSeconds": {"longest_transaction", metric.GAUGE},
+}
+
+// innodbDeadlockMetrics are only reported once a deadlock was detected
+var innodbDeadlockMetrics = map[string][]interface{}{
+	"db.innodb.latestDeadlockTime": {"latest_deadlock", metric.ATTRIBUTE},
+}
+
+var (
+	historyListLengthRegex = regexp.MustCompile(`^History list length (\d+)`)
+	pendingReadsRegex      = regexp.MustCompile(`^Pending reads\s+(\d+)`)
+	pendingWritesRegex     = regexp.MustCompile(`^Pending writes: LRU (\d+), flush list (\d+), single page (\d+)`)
+	pendingFlushesRegex    = regexp.MustCompile(`^Pending flushes \(fsync\) log: (\d+); buffer pool: (\d+)`)
+	logSequenceNumberRegex = regexp.MustCompile(`^Log sequence number\s+(\d+)$`)
+	lastCheckpointRegex    = regexp.MustCompile(`^Last checkpoint at\s+(\d+)$`)
+	semaphoreWaitRegex     = regexp.MustCompile(`^--Thread \d+ has waited at .* for ([\d.]+) seconds the semaphore`)
+	activeTransactionRegex = regexp.MustCompile(`^---TRANSACTION \d+, ACTIVE (?:\(PREPARED\) )?(\d+) sec`)
+	sectionTitleRegex      = regexp.MustCompile(`^[A-Z][A-Z /]+$`)
+	timestampRegex         = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})`)
+)
+
+func checkpointAge(metrics map[string]interface{}) (float64, bool) {
+	lsn, ok1 := metrics["log_sequence_number"].(int)
+	checkpoint, ok2 := metrics["last_checkpoint"].(int)
+	return float64(lsn - checkpoint), ok1 && ok2
+}
+
+// sumMatches adds up the numbers captured by regex
+func sumMatches(match []string) int {
+	sum := 0
+	for _, value := range match[1:] {
+		n, _ := strconv.Atoi(value)
+		sum += n
+	}
+	return sum
+}
+
+// parseInnodbStatus extracts metrics from the text of SHOW ENGINE INNODB
+// STATUS, whose format changes between MySQL versions. Transactions active
+// for longTransaction seconds or more are counted as long.
+func parseInnodbStatus(status string, longTransaction int) map[string]interface{} {
+	rawMetrics := map[string]interface{}{
+		"semaphore_waits":     0,
+		"semaphore_max_wait":  0.0,
+		"long_transactions":   0,
+		"longest_transaction": 0,
+	}
+
+	section, underline := "", false
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// Section titles are surrounded by lines of dashes
+		if strings.Trim(line, "-=") == "" {
+			underline = true
+			continue
+		}
+		if underline && sectionTitleRegex.MatchString(line) {
+			section, underline = line, false
+			continue
+		}
+		underline = false
+
+		if match := historyListLengthRegex.FindStringSubmatch(line); match != nil {
+			rawMetrics["history_list_length"] = sumMatches(match)
+		} else if match = pendingReadsRegex.FindStringSubmatch(line); match != nil {
+			rawMetrics["pending_reads"] = sumMatches(match)
+		} else if match = pendingWritesRegex.FindStringSubmatch(line); match != nil {
+			rawMetrics["pending_writes"] = sumMatches(match)
+		} else if match = pendingFlushesRegex.FindStringSubmatch(line); match != nil {
+			rawMetrics["pending_log_flushes"], _ = strconv.Atoi(match[1])
+			rawMetrics["pending_buffer_pool_flushes"], _ = strconv.Atoi(match[2])
+		} else if match = logSequenceNumberRegex.FindStringSubmatch(line); match != nil {
+			rawMetrics["log_sequence_number"] = sumMatches(match)
+		} else if match = lastCheckpointRegex.FindStringSubmatch(line); match != nil {
+			rawMetrics["last_checkpoint"] = sumMatches(match)
+		} else if match = semaphoreWaitRegex.FindStringSubmatch(line); match != nil {
+			rawMetrics["semaphore_waits"] = rawMetrics["semaphore_waits"].(int) + 1
+			if wait, err := strconv.ParseFloat(match[1], 64); err == nil && wait > rawMetrics["semaphore_max_wait"].(float64) {
+				rawMetrics["semaphore_max_wait"] = wait
+			}
+		} else if match = activeTransactionRegex.FindStringSubmatch(line); match != nil {
+			active := sumMatches(match)
+			if active >= longTransaction {
+				rawMetrics["long_transactions"] = rawMetrics["long_transactions"].(int) + 1
+			}
+			if active > rawMetrics["longest_transaction"].(int) {
+				rawMetrics["longest_transaction"] = active
+			}
+		} else if section == "LATEST DETECTED DEADLOCK" {
+			// The section starts with the time of the deadlock
+			if _, ok := rawMetrics["latest_deadlock"]; !ok && timestampRegex.MatchString(line) {
+				rawMetrics["latest_deadlock"] = timestampRegex.FindString(line)
+			}
+		}
+	}
+	return rawMetrics
+}
+
+// populateInnodbStatusMetrics adds the metrics of SHOW ENGINE INNODB STATUS
+// to sample
+func populateInnodbStatusMetrics(sample *metric.MetricSet, db dataSource, longTransaction int) error {
+	rows, err := db.queryRows(innodbStatusQuery)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("Empty InnoDB status")
+	}
+	status, ok := rows[0].getString("Status")
+	if !ok {
+		return fmt.Errorf("Empty InnoDB status")
+	}
+
+	rawMetrics := parseInnodbStatus(status, longTransaction)
+	populatePartialMetrics(sample, rawMetrics, innodbStatusMetrics)
+	if _, ok := rawMetrics["latest_deadlock"]; ok {
+		populatePartialMetrics(sample, rawMetrics, innodbDeadlockMetrics)
+	}
+	return nil
+}
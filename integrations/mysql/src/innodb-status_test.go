@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/metric"
+)
+
+func readInnodbStatus(t *testing.T, version string) string {
+	status, err := ioutil.ReadFile(filepath.Join("testdata", "innodb-status-"+version+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(status)
+}
+
+func TestParseInnodbStatus(t *testing.T) {
+	cases := []struct {
+		version  string
+		expected map[string]interface{}
+	}{
+		{"5.6", map[string]interface{}{
+			"history_list_length":         1942,
+			"pending_reads":               2,
+			"pending_writes":              1,
+			"pending_log_flushes":         1,
+			"pending_buffer_pool_flushes": 0,
+			"log_sequence_number":         1697964561,
+			"last_checkpoint":             1697881234,
+			"semaphore_waits":             2,
+			"semaphore_max_wait":          12.0,
+			"long_transactions":           1,
+			"longest_transaction":         95,
+			"latest_deadlock":             "2017-09-13 22:04:11",
+		}},
+		{"5.7", map[string]interface{}{
+			"history_list_length":         23,
+			"pending_reads":               0,
+			"pending_writes":              0,
+			"pending_log_flushes":         0,
+			"pending_buffer_pool_flushes": 0,
+			"log_sequence_number":         12664920,
+			"last_checkpoint":             12664911,
+			"semaphore_waits":             0,
+			"semaphore_max_wait":          0.0,
+			"long_transactions":           1,
+			"longest_transaction":         61,
+		}},
+		{"8.0", map[string]interface{}{
+			"history_list_length":         105871,
+			"pending_reads":               1,
+			"pending_writes":              4,
+			"pending_log_flushes":         0,
+			"pending_buffer_pool_flushes": 2,
+			"log_sequence_number":         2638751926,
+			"last_checkpoint":             2636654774,
+			"semaphore_waits":             1,
+			"semaphore_max_wait":          241.0,
+			"long_transactions":           3,
+			"longest_transaction":         3605,
+			"latest_deadlock":             "2019-02-11 17:08:31",
+		}},
+	}
+
+	for _, c := range cases {
+		rawMetrics := parseInnodbStatus(readInnodbStatus(t, c.version), 60)
+		if len(rawMetrics) != len(c.expected) {
+			t.Errorf("For MySQL %s, expected metrics: %v. Actual metrics: %v", c.version, c.expected, rawMetrics)
+		}
+		for key, expected := range c.expected {
+			if rawMetrics[key] != expected {
+				t.Errorf("For MySQL %s and %s, expected value: %v. Actual value: %v", c.version, key, expected, rawMetrics[key])
+			}
+		}
+	}
+}
+
+func TestPopulateInnodbStatusMetrics(t *testing.T) {
+	db := fakeDataSource{
+		rows: map[string][]row{innodbStatusQuery: {
+			{"Type": "InnoDB", "Name": "", "Status": readInnodbStatus(t, "8.0")},
+		}},
+	}
+
+	sample := metric.NewMetricSet("MysqlSample")
+	if err := populateInnodbStatusMetrics(&sample, db, 300); err != nil {
+		t.Fatal(err)
+	}
+	if sample["db.innodb.checkpointAgeBytes"] != float64(2097152) || sample["db.innodb.historyListLength"] != 105871 {
+		t.Error()
+	}
+	if sample["db.innodb.longTransactions"] != 2 || sample["db.innodb.latestDeadlockTime"] != "2019-02-11 17:08:31" {
+		t.Error()
+	}
+
+	sample = metric.NewMetricSet("MysqlSample")
+	if err := populateInnodbStatusMetrics(&sample, fakeDataSource{}, 60); err == nil {
+		t.Error()
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+// mysqlInstance is a server monitored along with others in the same run. The
+// connection fields left empty or unset are taken from the integration
+// arguments.
+type mysqlInstance struct {
+	Name                  string `yaml:"name"`
+	Hostname              string `yaml:"hostname"`
+	Port                  int    `yaml:"port"`
+	Socket                string `yaml:"socket"`
+	Username              string `yaml:"username"`
+	Password              string `yaml:"password"`
+	Database              string `yaml:"database"`
+	OptionFile            string `yaml:"option_file"`
+	EnableTLS             *bool  `yaml:"enable_tls"`
+	TLSInsecureSkipVerify *bool  `yaml:"tls_insecure_skip_verify"`
+	CABundle              string `yaml:"ca_bundle"`
+	ClientCert            string `yaml:"client_cert"`
+	ClientKey             string `yaml:"client_key"`
+	TLSServerName         string `yaml:"tls_server_name"`
+}
+
+type instancesConfig struct {
+	Instances []mysqlInstance `yaml:"instances"`
+}
+
+// arguments returns the arguments to connect to the instance with
+func (i mysqlInstance) arguments(base argumentList) argumentList {
+	fields := map[*string]string{
+		&base.Hostname:      i.Hostname,
+		&base.Socket:        i.Socket,
+		&base.Username:      i.Username,
+		&base.Password:      i.Password,
+		&base.Database:      i.Database,
+		&base.OptionFile:    i.OptionFile,
+		&base.CABundle:      i.CABundle,
+		&base.ClientCert:    i.ClientCert,
+		&base.ClientKey:     i.ClientKey,
+		&base.TLSServerName: i.TLSServerName,
+	}
+	for field, value := range fields {
+		if value != "" {
+			*field = value
+		}
+	}
+	if i.Port != 0 {
+		base.Port = i.Port
+	}
+	// The flags are pointers so that an instance can turn them off too
+	if i.EnableTLS != nil {
+		base.EnableTLS = *i.EnableTLS
+	}
+	if i.TLSInsecureSkipVerify != nil {
+		base.TLSInsecureSkipVerify = *i.TLSInsecureSkipVerify
+	}
+	return base
+}
+
+// loadInstances returns the instances of the instances_config file followed
+// by the ones of the instances argument. Instances without a name are named
+// after their socket or address, and names must be unique since they
+// identify the samples and cached values of each instance.
+func loadInstances() ([]mysqlInstance, error) {
+	config := instancesConfig{}
+	if args.InstancesConfig != "" {
+		content, err := ioutil.ReadFile(args.InstancesConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read instances: %s", err)
+		}
+		if err = yaml.Unmarshal(content, &config); err != nil {
+			return nil, fmt.Errorf("Can't parse instances in %s: %s", args.InstancesConfig, err)
+		}
+	}
+
+	if value := args.Instances.Get(); value != nil {
+		// JSON is valid YAML, so both share the same definitions
+		content, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		var instances []mysqlInstance
+		if err = yaml.Unmarshal(content, &instances); err != nil {
+			return nil, fmt.Errorf("Instances must be a JSON array of instances: %s", err)
+		}
+		config.Instances = append(config.Instances, instances...)
+	}
+
+	names := make(map[string]bool)
+	for n := range config.Instances {
+		instance := &config.Instances[n]
+		if instance.Name == "" {
+			arguments := instance.arguments(args)
+			instance.Name = arguments.Socket
+			if instance.Name == "" {
+				instance.Name = net.JoinHostPort(arguments.Hostname, strconv.Itoa(arguments.Port))
+			}
+		}
+		if names[instance.Name] {
+			return nil, fmt.Errorf("Duplicated instance %s", instance.Name)
+		}
+		names[instance.Name] = true
+	}
+	return config.Instances, nil
+}
+
+// populateInstances collects the instances concurrently, at most
+// args.InstanceConcurrency at a time, and reports their samples tagged with
+// instance.name. An instance that fails is reported by a sample with the
+// error instead.
+func populateInstances(integration *sdk.Integration, instances []mysqlInstance, queries []customQuery) {
+	arguments := make([]argumentList, len(instances))
+	results := make([]*sdk.Integration, len(instances))
+	errs := make([]error, len(instances))
+
+	// The option files are read and the TLS configurations registered
+	// beforehand, since the driver's registry isn't safe for concurrent use
+	for i, instance := range instances {
+		arguments[i] = instance.arguments(args)
+		if errs[i] = applyOptionFile(&arguments[i]); errs[i] == nil {
+			errs[i] = registerTLSConfig(arguments[i])
+		}
+	}
+
+	workers := args.InstanceConcurrency
+	if workers < 1 || workers > len(instances) {
+		workers = len(instances)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = collectInstance(instances[i], arguments[i], queries)
+			}
+		}()
+	}
+	for i := range instances {
+		if errs[i] == nil {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// The samples are added in the order of the definitions
+	for i, instance := range instances {
+		if errs[i] != nil {
+			log.Warn("Can't get metrics for instance %s: %s", instance.Name, errs[i])
+			populateInstanceError(integration, instance, errs[i])
+			continue
+		}
+		mergeInstance(integration, instance, results[i])
+	}
+}
+
+// collectInstance reports the samples and inventory of an instance in an
+// integration of its own, so that instances don't share any state but the
+// cache. The custom queries are run on every instance.
+func collectInstance(instance mysqlInstance, arguments argumentList, queries []customQuery) (*sdk.Integration, error) {
+	instanceIntegration := &sdk.Integration{Inventory: make(sdk.Inventory)}
+	sample := instanceIntegration.NewMetricSet("MysqlSample")
+	err := populateInstance(instanceIntegration, sample, arguments, queries, "instance/"+instance.Name)
+	return instanceIntegration, err
+}
+
+// mergeInstance adds the samples of an instance to the integration, and its
+// inventory items prefixed by the instance name.
+func mergeInstance(integration *sdk.Integration, instance mysqlInstance, instanceIntegration *sdk.Integration) {
+	for _, ms := range instanceIntegration.Metrics {
+		ms.SetMetric("instance.name", instance.Name, metric.ATTRIBUTE)
+		integration.Metrics = append(integration.Metrics, ms)
+	}
+	for key, item := range instanceIntegration.Inventory {
+		integration.Inventory[instance.Name+"/"+key] = item
+	}
+}
+
+// populateInstanceError reports an instance that could not be collected
+func populateInstanceError(integration *sdk.Integration, instance mysqlInstance, err error) {
+	sample := integration.NewMetricSet("MysqlSample")
+	sample.SetMetric("instance.name", instance.Name, metric.ATTRIBUTE)
+	sample.SetMetric("instance.error", err.Error(), metric.ATTRIBUTE)
+}
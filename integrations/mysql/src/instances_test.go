@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/cache"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+var testInstancesConfig = `instances:
+  - name: primary
+    port: 3307
+    username: root
+  - socket: /var/run/mysqld/replica.sock
+    enable_tls: true
+`
+
+func TestInstanceArguments(t *testing.T) {
+	enabled := true
+	base := argumentList{Hostname: "localhost", Port: 3306, Username: "newrelic", Password: "secret", ReadTimeout: 10}
+	arguments := mysqlInstance{Hostname: "db.local", Password: "other", EnableTLS: &enabled}.arguments(base)
+	if arguments.Hostname != "db.local" || arguments.Port != 3306 || arguments.Username != "newrelic" || arguments.Password != "other" {
+		t.Error()
+	}
+	if !arguments.EnableTLS || arguments.ReadTimeout != 10 {
+		t.Error()
+	}
+	// The base arguments are left untouched
+	if base.Hostname != "localhost" || base.EnableTLS {
+		t.Error()
+	}
+}
+
+func TestInstanceArgumentsTurnTLSOff(t *testing.T) {
+	disabled := false
+	base := argumentList{Hostname: "localhost", EnableTLS: true, TLSInsecureSkipVerify: true}
+
+	arguments := mysqlInstance{EnableTLS: &disabled, TLSInsecureSkipVerify: &disabled}.arguments(base)
+	if arguments.EnableTLS || arguments.TLSInsecureSkipVerify {
+		t.Error()
+	}
+	// Unset flags are inherited
+	arguments = mysqlInstance{}.arguments(base)
+	if !arguments.EnableTLS || !arguments.TLSInsecureSkipVerify {
+		t.Error()
+	}
+}
+
+func TestLoadInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "instances.yml")
+	if err = ioutil.WriteFile(path, []byte(testInstancesConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	args = argumentList{Hostname: "localhost", Port: 3306, InstancesConfig: path}
+	defer func() { args = argumentList{} }()
+	args.Instances.Set(`[{"hostname": "db.local"}]`)
+
+	instances, err := loadInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 3 {
+		t.Fatalf("Expected 3 instances, got %d", len(instances))
+	}
+	if instances[0].Name != "primary" || instances[0].Port != 3307 || instances[0].Username != "root" {
+		t.Error()
+	}
+	if instances[1].Name != "/var/run/mysqld/replica.sock" || instances[1].EnableTLS == nil || !*instances[1].EnableTLS {
+		t.Error()
+	}
+	if instances[2].Name != "db.local:3306" || instances[2].EnableTLS != nil {
+		t.Error()
+	}
+
+	args.Instances.Set(`[{"name": "primary"}]`)
+	if _, err = loadInstances(); err == nil {
+		t.Error()
+	}
+
+	args.Instances.Set(`{"name": "primary"}`)
+	if _, err = loadInstances(); err == nil {
+		t.Error()
+	}
+}
+
+func TestPopulateInstancesWithErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	args = argumentList{Hostname: "localhost", Port: 3306, ConnectTimeout: 1, InstanceConcurrency: 2}
+	args.Metrics = true
+	defer func() { args = argumentList{} }()
+
+	instances := []mysqlInstance{
+		{Name: "first", Socket: filepath.Join(dir, "first.sock")},
+		{Name: "second", Socket: filepath.Join(dir, "second.sock")},
+		{Name: "third", OptionFile: filepath.Join(dir, "missing.cnf")},
+	}
+	integration := &sdk.Integration{Inventory: make(sdk.Inventory)}
+	populateInstances(integration, instances, nil)
+
+	if len(integration.Metrics) != 3 {
+		t.Fatalf("Expected 3 samples, got %d", len(integration.Metrics))
+	}
+	for i, ms := range integration.Metrics {
+		if (*ms)["event_type"] != "MysqlSample" || (*ms)["instance.name"] != instances[i].Name || (*ms)["instance.error"] == nil {
+			t.Error()
+		}
+	}
+}
+
+func TestMergeInstance(t *testing.T) {
+	instanceIntegration := &sdk.Integration{Inventory: make(sdk.Inventory)}
+	instanceIntegration.NewMetricSet("MysqlSample")
+	instanceIntegration.NewMetricSet("MysqlTableSample")
+	populateInventory(instanceIntegration.Inventory, map[string]interface{}{"version": "5.7.20"})
+
+	integration := &sdk.Integration{Inventory: make(sdk.Inventory)}
+	mergeInstance(integration, mysqlInstance{Name: "primary"}, instanceIntegration)
+	if len(integration.Metrics) != 2 {
+		t.Fatal()
+	}
+	for _, ms := range integration.Metrics {
+		if (*ms)["instance.name"] != "primary" {
+			t.Error()
+		}
+	}
+	if integration.Inventory["primary/version"]["value"] != "5.7.20" {
+		t.Error()
+	}
+
+	populateInstanceError(integration, mysqlInstance{Name: "replica"}, errors.New("Connection refused"))
+	if (*integration.Metrics[2])["instance.error"] != "Connection refused" {
+		t.Error()
+	}
+}
+
+func TestPopulateNamespacedMetrics(t *testing.T) {
+	definition := map[string][]interface{}{
+		"db.queriesPerSecond": {"Queries", metric.RATE},
+	}
+
+	now := time.Now()
+	cache.SetNow(func() time.Time { return now })
+	defer cache.SetNow(time.Now)
+
+	for _, queries := range []int{100, 200} {
+		for _, namespace := range []string{"instance/primary", "instance/replica"} {
+			ms := metric.NewMetricSet("MysqlSample")
+			populateNamespacedMetrics(&ms, map[string]interface{}{"Queries": queries}, definition, namespace)
+		}
+		now = now.Add(10 * time.Second)
+	}
+
+	// Each instance keeps its own previous value
+	ms := metric.NewMetricSet("MysqlSample")
+	populateNamespacedMetrics(&ms, map[string]interface{}{"Queries": 300}, definition, "instance/primary")
+	if ms["db.queriesPerSecond"] != float64(10) {
+		t.Errorf("Expected 10 queries per second, got %v", ms["db.queriesPerSecond"])
+	}
+}
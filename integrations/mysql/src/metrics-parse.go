@@ -6,6 +6,7 @@ import (
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/newrelic/infra-integrations-sdk/metric"
 	"github.com/newrelic/infra-integrations-sdk/sdk"
+	"github.com/newrelic/infra-integrations/pkg/sampler"
 )
 
 const (
@@ -39,13 +40,20 @@ func getRawData(db dataSource) (map[string]interface{}, map[string]interface{},
 	if err != nil {
 		return nil, nil, err
 	}
-	replication, err := db.query(replicaQuery)
+	replication, err := db.queryRows(replicaQuery)
 	if err != nil {
 		log.Warn("Can't get node type, not enough privileges (must grant REPLICATION CLIENT)")
 	} else if len(replication) == 0 {
 		metrics["node_type"] = "master"
 	} else {
 		metrics["node_type"] = "slave"
+		// Kept for the replica samples, so that the status is queried once
+		metrics["replicas"] = replication
+	}
+	// A Galera node may also replicate from an asynchronous master, but its
+	// role in the cluster matters most
+	if isGalera(metrics) {
+		metrics["node_type"] = galeraRole(metrics)
 	}
 
 	// Set needed values for computed metrics from inventory
@@ -69,20 +77,33 @@ func populateInventory(inventory sdk.Inventory, rawData map[string]interface{})
 	}
 }
 
-func populateMetrics(sample *metric.MetricSet, rawMetrics map[string]interface{}) {
-	populatePartialMetrics(sample, rawMetrics, defaultMetrics)
+// populateMetrics reports the status metrics of a server. When more than one
+// instance is monitored, namespace must identify the instance so its rates
+// are computed apart from the other instances' ones.
+func populateMetrics(sample *metric.MetricSet, rawMetrics map[string]interface{}, namespace string) {
+	populateNamespacedMetrics(sample, rawMetrics, defaultMetrics, namespace)
 	if args.ExtendedMetrics {
-		populatePartialMetrics(sample, rawMetrics, extendedMetrics)
+		populateNamespacedMetrics(sample, rawMetrics, extendedMetrics, namespace)
 	}
 	if args.ExtendedInnodbMetrics {
-		populatePartialMetrics(sample, rawMetrics, innodbMetrics)
+		populateNamespacedMetrics(sample, rawMetrics, innodbMetrics, namespace)
 	}
 	if args.ExtendedMyIsamMetrics {
-		populatePartialMetrics(sample, rawMetrics, myisamMetrics)
+		populateNamespacedMetrics(sample, rawMetrics, myisamMetrics, namespace)
+	}
+	if isGalera(rawMetrics) {
+		populateNamespacedMetrics(sample, rawMetrics, galeraMetrics, namespace)
 	}
 
 }
+
 func populatePartialMetrics(sample *metric.MetricSet, metrics map[string]interface{}, metricsDefinition map[string][]interface{}) {
+	populateNamespacedMetrics(sample, metrics, metricsDefinition, "")
+}
+
+// populateNamespacedMetrics works like populatePartialMetrics, but samples the
+// RATE and DELTA metrics under the namespace of an instance.
+func populateNamespacedMetrics(sample *metric.MetricSet, metrics map[string]interface{}, metricsDefinition map[string][]interface{}, namespace string) {
 	for metricName, metricConf := range metricsDefinition {
 		rawSource := metricConf[0]
 		metricType := metricConf[1].(metric.SourceType)
@@ -105,6 +126,15 @@ func populatePartialMetrics(sample *metric.MetricSet, metrics map[string]interfa
 			continue
 		}
 
+		if namespace != "" && (metricType == metric.RATE || metricType == metric.DELTA) {
+			sampled, err := sampler.Sample(sampler.Key(namespace, metricName), rawMetric, metricType)
+			if err != nil {
+				log.Warn("Error setting value: %s", err)
+				continue
+			}
+			rawMetric, metricType = sampled, metric.GAUGE
+		}
+
 <<<<<<< HEAD
 		sample.AddMetric(metricName, rawMetric, metricType)
 =======
@@ -1,10 +1,9 @@
 package main
 
 import (
-	"fmt"
-
 	sdk_args "github.com/newrelic/infra-integrations-sdk/args"
 	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
 	"github.com/newrelic/infra-integrations-sdk/sdk"
 )
 
@@ -20,18 +19,42 @@ const (
 
 type argumentList struct {
 	sdk_args.DefaultArgumentList
-	Hostname              string `default:"localhost" help:"Hostname or IP where MySQL is running."`
-	Port                  int    `default:"3306" help:"Port on which MySQL server is listening."`
-	Username              string `help:"Username for accessing the database."`
-	Password              string `help:"Password for the given user."`
-	Database              string `help:"Database name"`
-	ExtendedMetrics       bool   `default:"false" help:"Enable extended metrics"`
-	ExtendedInnodbMetrics bool   `default:"false" help:"Enable InnoDB extended metrics"`
-	ExtendedMyIsamMetrics bool   `default:"false" help:"Enable MyISAM extended metrics"`
-}
-
-func generateDSN(args argumentList) string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", args.Username, args.Password, args.Hostname, args.Port, args.Database)
+	Hostname                     string        `default:"localhost" help:"Hostname or IP where MySQL is running."`
+	Port                         int           `default:"3306" help:"Port on which MySQL server is listening."`
+	Username                     string        `help:"Username for accessing the database."`
+	Password                     string        `help:"Password for the given user."`
+	Database                     string        `help:"Database name"`
+	Socket                       string        `default:"" help:"Unix socket to connect to instead of hostname and port."`
+	OptionFile                   string        `default:"" help:"MySQL option file whose [client] group provides the connection arguments not given, e.g. ~/.my.cnf."`
+	EnableTLS                    bool          `default:"false" help:"Connect to MySQL with TLS."`
+	TLSInsecureSkipVerify        bool          `default:"false" help:"Don't verify the certificate of the server."`
+	CABundle                     string        `default:"" help:"PEM file with the CAs to verify the server certificate with. Enables TLS."`
+	ClientCert                   string        `default:"" help:"PEM client certificate to authenticate with. Enables TLS."`
+	ClientKey                    string        `default:"" help:"PEM key of the client certificate."`
+	TLSServerName                string        `default:"" help:"Name to verify the server certificate against, the hostname by default. Enables TLS."`
+	ConnectTimeout               int           `default:"5" help:"Seconds to wait for the connection, 0 for no timeout."`
+	ReadTimeout                  int           `default:"0" help:"Seconds to wait for a query response, 0 for no timeout."`
+	WriteTimeout                 int           `default:"0" help:"Seconds to wait for a query to be sent, 0 for no timeout."`
+	ExtendedMetrics              bool          `default:"false" help:"Enable extended metrics"`
+	ExtendedInnodbMetrics        bool          `default:"false" help:"Enable InnoDB extended metrics"`
+	ExtendedMyIsamMetrics        bool          `default:"false" help:"Enable MyISAM extended metrics"`
+	InnodbStatusMetrics          bool          `default:"false" help:"Report the metrics of SHOW ENGINE INNODB STATUS."`
+	InnodbLongTransactionSeconds int           `default:"60" help:"Seconds after which an InnoDB transaction is counted as long."`
+	ProcesslistMetrics           bool          `default:"false" help:"Report the connections, long running queries and lock waits of the processlist."`
+	LongRunningQuerySeconds      int           `default:"60" help:"Seconds after which a query is counted as long running."`
+	QueryTextLength              int           `default:"256" help:"Maximum length of the reported query texts, 0 for no limit."`
+	ObfuscateQueries             bool          `default:"true" help:"Replace the literals of the reported query texts by ?."`
+	TableMetrics                 bool          `default:"false" help:"Report the size of the largest tables in MysqlTableSample."`
+	TableMetricsInclude          string        `default:"" help:"Regex of the schema.table names to report, all by default."`
+	TableMetricsExclude          string        `default:"" help:"Regex of the schema.table names not to report."`
+	TableMetricsLimit            int           `default:"20" help:"Maximum number of tables to report, 0 for no limit."`
+	DigestMetrics                bool          `default:"false" help:"Report the statement digests with the highest latency since the previous run."`
+	DigestMetricsLimit           int           `default:"10" help:"Maximum number of statement digests to report, 0 for no limit."`
+	CustomQueriesConfig          string        `default:"" help:"YAML file with custom queries to report in their own samples."`
+	CustomQueries                sdk_args.JSON `default:"" help:"JSON array of custom queries, with the same fields as custom_queries_config."`
+	InstancesConfig              string        `default:"" help:"YAML file with the MySQL instances to monitor instead of the one given by the connection arguments."`
+	Instances                    sdk_args.JSON `default:"" help:"JSON array of MySQL instances to monitor, with the same fields as instances_config."`
+	InstanceConcurrency          int           `default:"4" help:"Maximum number of instances collected at the same time."`
 }
 
 var args argumentList
@@ -41,28 +64,87 @@ func main() {
 	fatalIfErr(err)
 	log.SetupLogging(args.Verbose)
 
+	instances, err := loadInstances()
+	fatalIfErr(err)
+	queries, err := loadCustomQueries()
+	fatalIfErr(err)
+	if len(instances) > 0 {
+		populateInstances(integration, instances, queries)
+		fatalIfErr(integration.Publish())
+		return
+	}
+
 <<<<<<< HEAD
 	sample := integration.NewMetricSet("DatastoreSample", "MySQL")
 =======
 	sample := integration.NewMetricSet("MysqlSample")
 >>>>>>> upstream/master
 
-	db, err := openDB(generateDSN(args))
-	fatalIfErr(err)
+	fatalIfErr(applyOptionFile(&args))
+	fatalIfErr(registerTLSConfig(args))
+	fatalIfErr(populateInstance(integration, sample, args, queries, ""))
+	fatalIfErr(integration.Publish())
+}
+
+// populateInstance reports the inventory and samples of the server arguments
+// connects to, and the results of the custom queries. The RATE and DELTA
+// metrics are cached under namespace.
+func populateInstance(integration *sdk.Integration, sample *metric.MetricSet, arguments argumentList, queries []customQuery, namespace string) error {
+	db, err := openDB(generateDSN(arguments))
+	if err != nil {
+		return err
+	}
 	defer db.close()
+	return populateDataSource(integration, sample, db, arguments, queries, namespace)
+}
 
+// populateDataSource reports the inventory and samples of a server. The
+// optional metrics that can't be collected are skipped with a warning.
+func populateDataSource(integration *sdk.Integration, sample *metric.MetricSet, db dataSource, arguments argumentList, queries []customQuery, namespace string) error {
 	rawInventory, rawMetrics, err := getRawData(db)
-	fatalIfErr(err)
+	if err != nil {
+		return err
+	}
 
-	if args.All || args.Inventory {
+	if arguments.All || arguments.Inventory {
 		populateInventory(integration.Inventory, rawInventory)
 	}
 
-	if args.All || args.Metrics {
-		populateMetrics(sample, rawMetrics)
-	}
+	if arguments.All || arguments.Metrics {
+		populateMetrics(sample, rawMetrics, namespace)
+		if replicas, ok := rawMetrics["replicas"].([]row); ok {
+			populateReplicaMetrics(integration, replicas)
+		}
 
-	fatalIfErr(integration.Publish())
+		if arguments.InnodbStatusMetrics {
+			if err = populateInnodbStatusMetrics(sample, db, arguments.InnodbLongTransactionSeconds); err != nil {
+				log.Warn("Can't get InnoDB status, the PROCESS privilege is required: %s", err)
+			}
+		}
+
+		if arguments.ProcesslistMetrics {
+			if err = populateProcesslistMetrics(integration, sample, db); err != nil {
+				log.Warn("Can't get the processlist: %s", err)
+			}
+		}
+
+		if arguments.TableMetrics {
+			if err = populateTableMetrics(integration, db); err != nil {
+				log.Warn("Can't get the table sizes from information_schema: %s", err)
+			}
+		}
+
+		if arguments.DigestMetrics {
+			// performance_schema may be disabled or not granted, which shouldn't
+			// prevent the other metrics from being reported
+			if err = populateDigestMetrics(integration, db, arguments.DigestMetricsLimit, namespace); err != nil {
+				log.Warn("Can't get statement digests from performance_schema: %s", err)
+			}
+		}
+
+		populateCustomQueryMetrics(integration, db, queries, namespace)
+	}
+	return nil
 }
 
 func fatalIfErr(err error) {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/newrelic/infra-integrations-sdk/metric"
@@ -110,36 +111,16 @@ func TestPopulateInventory(t *testing.T) {
 	}
 }
 
-type testdb struct {
-	inventory map[string]interface{}
-	metrics   map[string]interface{}
-	replica   map[string]interface{}
-}
-
-func (d testdb) close() {}
-func (d testdb) query(query string) (map[string]interface{}, error) {
-	if query == inventoryQuery {
-		return d.inventory, nil
-	}
-	if query == metricsQuery {
-		return d.metrics, nil
-	}
-	if query == replicaQuery {
-		return d.replica, nil
-	}
-	return nil, nil
-}
-
 func TestGetRawData(t *testing.T) {
-	database := testdb{
-		inventory: map[string]interface{}{
-			"key_cache_block_size": 10,
-			"key_buffer_size":      10,
-			"version_comment":      "mysql",
-			"version":              "5.4.3",
+	database := fakeDataSource{
+		rows: map[string][]row{
+			inventoryQuery: variableRows(map[string]interface{}{
+				"key_cache_block_size": 10,
+				"key_buffer_size":      10,
+				"version_comment":      "mysql",
+				"version":              "5.4.3",
+			}),
 		},
-		metrics: map[string]interface{}{},
-		replica: map[string]interface{}{},
 	}
 	inventory, metrics, err := getRawData(database)
 	if err != nil {
@@ -151,6 +132,9 @@ func TestGetRawData(t *testing.T) {
 	if inventory == nil {
 		t.Error()
 	}
+	if metrics["node_type"] != "master" || metrics["version"] != "5.4.3" {
+		t.Error()
+	}
 }
 
 func TestPopulateMetricsWithZeroValuesInData(t *testing.T) {
@@ -189,3 +173,30 @@ func TestPopulateMetricsWithZeroValuesInData(t *testing.T) {
 		}
 	}
 }
+
+func TestPopulateDataSourceWithoutOptionalPrivileges(t *testing.T) {
+	denied := errors.New("Error 1142: SELECT command denied")
+	database := fakeDataSource{
+		rows: map[string][]row{
+			inventoryQuery: variableRows(map[string]interface{}{"version": "5.7.20"}),
+			metricsQuery:   variableRows(map[string]interface{}{"Queries": 10}),
+		},
+		errors: map[string]error{
+			digestsQuery:      denied,
+			innodbStatusQuery: denied,
+			processlistQuery:  denied,
+			tablesQuery:       denied,
+		},
+	}
+	arguments := argumentList{DigestMetrics: true, InnodbStatusMetrics: true, ProcesslistMetrics: true, TableMetrics: true}
+	arguments.Metrics = true
+
+	integration := &sdk.Integration{}
+	sample := integration.NewMetricSet("MysqlSample")
+	if err := populateDataSource(integration, sample, database, arguments, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if (*sample)["software.version"] != "5.7.20" || (*sample)["cluster.nodeType"] != "master" {
+		t.Error()
+	}
+}
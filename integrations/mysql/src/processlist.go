@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+const (
+	processlistQuery = `SELECT ID, USER, HOST, DB, COMMAND, TIME, STATE, INFO
+FROM information_schema.PROCESSLIST WHERE ID != CONNECTION_ID()`
+	lockWaitsQuery = `SELECT wait_age_secs, locked_table, locked_index, locked_type,
+waiting_trx_id, waiting_pid, waiting_query, blocking_trx_id, blocking_pid, blocking_query
+FROM sys.innodb_lock_waits`
+)
+
+// longRunningQueriesLimit caps the MysqlLongRunningQuerySample reported,
+// the longest running queries coming first
+const longRunningQueriesLimit = 10
+
+var processlistMetrics = map[string][]interface{}{
+	"db.longRunningQueries":         {"long_running_queries", metric.GAUGE},
+	"db.longestRunningQuerySeconds": {"longest_running_query", metric.GAUGE},
+}
+
+var lockWaitsMetrics = map[string][]interface{}{
+	"db.innodb.lockWaits": {"lock_waits", metric.GAUGE},
+}
+
+var connectionMetrics = map[string][]interface{}{
+	"connection.user":    {"USER", metric.ATTRIBUTE},
+	"connection.host":    {"HOST", metric.ATTRIBUTE},
+	"connection.db":      {"DB", metric.ATTRIBUTE},
+	"connection.command": {"COMMAND", metric.ATTRIBUTE},
+	"connection.count":   {"count", metric.GAUGE},
+}
+
+var longRunningQueryMetrics = map[string][]interface{}{
+	"query.id":          {"ID", metric.ATTRIBUTE},
+	"query.user":        {"USER", metric.ATTRIBUTE},
+	"query.host":        {"HOST", metric.ATTRIBUTE},
+	"query.db":          {"DB", metric.ATTRIBUTE},
+	"query.state":       {"STATE", metric.ATTRIBUTE},
+	"query.text":        {"INFO", metric.ATTRIBUTE},
+	"query.timeSeconds": {"TIME", metric.GAUGE},
+}
+
+var lockWaitMetrics = map[string][]interface{}{
+	"lockWait.waitAgeSeconds": {"wait_age_secs", metric.GAUGE},
+	"lockWait.lockedTable":    {"locked_table", metric.ATTRIBUTE},
+	"lockWait.lockedIndex":    {"locked_index", metric.ATTRIBUTE},
+	"lockWait.lockedType":     {"locked_type", metric.ATTRIBUTE},
+	"lockWait.waitingTrxId":   {"waiting_trx_id", metric.ATTRIBUTE},
+	"lockWait.waitingPid":     {"waiting_pid", metric.ATTRIBUTE},
+	"lockWait.waitingQuery":   {"waiting_query", metric.ATTRIBUTE},
+	"lockWait.blockingTrxId":  {"blocking_trx_id", metric.ATTRIBUTE},
+	"lockWait.blockingPid":    {"blocking_pid", metric.ATTRIBUTE},
+	"lockWait.blockingQuery":  {"blocking_query", metric.ATTRIBUTE},
+}
+
+var (
+	quotedLiteralRegex  = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"`)
+	numericLiteralRegex = regexp.MustCompile(`\b(?:0x[0-9a-fA-F]+|\d+(?:\.\d+)?(?:[eE][-+]?\d+)?)\b`)
+)
+
+// obfuscateQuery replaces the string and numeric literals of a query by ?
+func obfuscateQuery(query string) string {
+	query = quotedLiteralRegex.ReplaceAllString(query, "?")
+	return numericLiteralRegex.ReplaceAllString(query, "?")
+}
+
+// queryText prepares the text of a query to be reported, obfuscated when
+// args.ObfuscateQueries is set and cut to args.QueryTextLength characters.
+func queryText(query string) string {
+	if args.ObfuscateQueries {
+		query = obfuscateQuery(query)
+	}
+	if args.QueryTextLength > 0 && utf8.RuneCountInString(query) > args.QueryTextLength {
+		query = string([]rune(query)[:args.QueryTextLength]) + "..."
+	}
+	return query
+}
+
+// stringColumns converts the given columns of a row to strings, as values
+// like a database named "2017" are scanned as numbers. Missing columns are
+// set to def.
+func stringColumns(r row, def string, columns ...string) {
+	for _, column := range columns {
+		if value, ok := r.getString(column); ok {
+			r[column] = value
+		} else {
+			r[column] = def
+		}
+	}
+}
+
+// clientHost leaves out the port of the client address of a connection
+func clientHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// populateProcesslistMetrics reports the connections per user, host, db and
+// command, the queries running for longer than args.LongRunningQuerySeconds
+// and the InnoDB lock waits when the sys schema is available.
+func populateProcesslistMetrics(integration *sdk.Integration, sample *metric.MetricSet, db dataSource) error {
+	processes, err := db.queryRows(processlistQuery)
+	if err != nil {
+		return err
+	}
+
+	connections := make(map[string]row)
+	longRunning := make([]row, 0)
+	for _, process := range processes {
+		stringColumns(process, "", "ID", "USER", "DB", "COMMAND", "STATE", "INFO")
+		host, _ := process.getString("HOST")
+		process["HOST"] = clientHost(host)
+
+		key := strings.Join([]string{process["USER"].(string), process["HOST"].(string), process["DB"].(string), process["COMMAND"].(string)}, "\x00")
+		if group, ok := connections[key]; ok {
+			group["count"] = group["count"].(int) + 1
+		} else {
+			connections[key] = row{"USER": process["USER"], "HOST": process["HOST"], "DB": process["DB"], "COMMAND": process["COMMAND"], "count": 1}
+		}
+
+		seconds, _ := process.getInt("TIME")
+		if process["COMMAND"] == "Query" && process["USER"] != "system user" && seconds >= args.LongRunningQuerySeconds {
+			longRunning = append(longRunning, process)
+		}
+	}
+
+	for _, group := range connections {
+		ms := integration.NewMetricSet("MysqlConnectionSample")
+		populatePartialMetrics(ms, group, connectionMetrics)
+	}
+
+	sort.SliceStable(longRunning, func(i, j int) bool {
+		ti, _ := longRunning[i].getInt("TIME")
+		tj, _ := longRunning[j].getInt("TIME")
+		return ti > tj
+	})
+	longest := 0
+	if len(longRunning) > 0 {
+		longest, _ = longRunning[0].getInt("TIME")
+	}
+	for i, process := range longRunning {
+		if i == longRunningQueriesLimit {
+			break
+		}
+		process["INFO"] = queryText(process["INFO"].(string))
+		ms := integration.NewMetricSet("MysqlLongRunningQuerySample")
+		populatePartialMetrics(ms, process, longRunningQueryMetrics)
+	}
+	populatePartialMetrics(sample, map[string]interface{}{
+		"long_running_queries":  len(longRunning),
+		"longest_running_query": longest,
+	}, processlistMetrics)
+
+	populateLockWaitMetrics(integration, sample, db)
+	return nil
+}
+
+// populateLockWaitMetrics reports a MysqlLockWaitSample per pair of blocked
+// and blocking transactions, from the sys schema of MySQL 5.7 and later.
+func populateLockWaitMetrics(integration *sdk.Integration, sample *metric.MetricSet, db dataSource) {
+	lockWaits, err := db.queryRows(lockWaitsQuery)
+	if err != nil {
+		log.Debug("Can't get InnoDB lock waits, the sys schema may not be installed: %s", err)
+		return
+	}
+
+	for _, lockWait := range lockWaits {
+		stringColumns(lockWait, "", "locked_table", "locked_index", "locked_type", "waiting_trx_id", "waiting_pid",
+			"waiting_query", "blocking_trx_id", "blocking_pid", "blocking_query")
+		lockWait["waiting_query"] = queryText(lockWait["waiting_query"].(string))
+		lockWait["blocking_query"] = queryText(lockWait["blocking_query"].(string))
+
+		ms := integration.NewMetricSet("MysqlLockWaitSample")
+		populatePartialMetrics(ms, lockWait, lockWaitMetrics)
+	}
+	populatePartialMetrics(sample, map[string]interface{}{"lock_waits": len(lockWaits)}, lockWaitsMetrics)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+func TestObfuscateQuery(t *testing.T) {
+	queries := map[string]string{
+		"SELECT * FROM orders WHERE id = 17":                                      "SELECT * FROM orders WHERE id = ?",
+		"UPDATE users SET name = 'O''Brien', ratio = 0.5 WHERE email = \"a@b.c\"": "UPDATE users SET name = ?, ratio = ? WHERE email = ?",
+		"SELECT * FROM table1 WHERE hash = 0xFF AND note = 'it\\'s 42'":           "SELECT * FROM table1 WHERE hash = ? AND note = ?",
+		"INSERT INTO t2 VALUES (1, 2e10, -3)":                                     "INSERT INTO t2 VALUES (?, ?, -?)",
+	}
+	for query, expected := range queries {
+		if actual := obfuscateQuery(query); actual != expected {
+			t.Errorf("For query '%s', expected: '%s'. Actual: '%s'", query, expected, actual)
+		}
+	}
+}
+
+func TestQueryText(t *testing.T) {
+	defer func() { args = argumentList{} }()
+
+	args = argumentList{ObfuscateQueries: true, QueryTextLength: 20}
+	if text := queryText("SELECT * FROM orders WHERE id = 17"); text != "SELECT * FROM orders..." {
+		t.Error(text)
+	}
+	args = argumentList{}
+	if text := queryText("SELECT * FROM orders WHERE id = 17"); text != "SELECT * FROM orders WHERE id = 17" {
+		t.Error(text)
+	}
+}
+
+func TestPopulateProcesslistMetrics(t *testing.T) {
+	db := fakeDataSource{
+		rows: map[string][]row{
+			processlistQuery: {
+				{"ID": 1, "USER": "system user", "HOST": "", "COMMAND": "Query", "TIME": 3600, "STATE": "Slave has read all relay log"},
+				{"ID": 10, "USER": "app", "HOST": "10.0.0.5:53210", "DB": "shop", "COMMAND": "Sleep", "TIME": 5, "STATE": ""},
+				{"ID": 11, "USER": "app", "HOST": "10.0.0.5:53211", "DB": "shop", "COMMAND": "Sleep", "TIME": 1, "STATE": ""},
+				{"ID": 12, "USER": "app", "HOST": "10.0.0.6:40100", "DB": "shop", "COMMAND": "Query", "TIME": 125, "STATE": "Sending data", "INFO": "SELECT * FROM orders WHERE customer = 'alice'"},
+				{"ID": 13, "USER": "report", "HOST": "localhost", "DB": 2017, "COMMAND": "Query", "TIME": 300, "STATE": "Sorting result", "INFO": "SELECT SUM(total) FROM sales WHERE year = 2017"},
+				{"ID": 14, "USER": "app", "HOST": "10.0.0.6:40101", "DB": "shop", "COMMAND": "Query", "TIME": 0, "STATE": "updating", "INFO": "UPDATE orders SET paid = 1 WHERE id = 3"},
+			},
+			lockWaitsQuery: {
+				{"wait_age_secs": 12, "locked_table": "`shop`.`orders`", "locked_index": "PRIMARY", "locked_type": "RECORD",
+					"waiting_trx_id": 5203, "waiting_pid": 14, "waiting_query": "UPDATE orders SET paid = 1 WHERE id = 3",
+					"blocking_trx_id": 5201, "blocking_pid": 12},
+			},
+		},
+	}
+
+	args = argumentList{LongRunningQuerySeconds: 60, ObfuscateQueries: true}
+	defer func() { args = argumentList{} }()
+
+	integration := &sdk.Integration{}
+	sample := integration.NewMetricSet("MysqlSample")
+	if err := populateProcesslistMetrics(integration, sample, db); err != nil {
+		t.Fatal(err)
+	}
+	if (*sample)["db.longRunningQueries"] != 2 || (*sample)["db.longestRunningQuerySeconds"] != 300 || (*sample)["db.innodb.lockWaits"] != 1 {
+		t.Error()
+	}
+
+	connections := make(map[string]interface{})
+	longRunning := make([]metric.MetricSet, 0)
+	lockWaits := make([]metric.MetricSet, 0)
+	for _, ms := range integration.Metrics {
+		switch (*ms)["event_type"] {
+		case "MysqlConnectionSample":
+			key := fmt.Sprintf("%s@%s/%s %s", (*ms)["connection.user"], (*ms)["connection.host"], (*ms)["connection.db"], (*ms)["connection.command"])
+			connections[key] = (*ms)["connection.count"]
+		case "MysqlLongRunningQuerySample":
+			longRunning = append(longRunning, *ms)
+		case "MysqlLockWaitSample":
+			lockWaits = append(lockWaits, *ms)
+		}
+	}
+
+	if len(connections) != 4 || connections["app@10.0.0.5/shop Sleep"] != 2 || connections["report@localhost/2017 Query"] != 1 {
+		t.Errorf("unexpected connections %v", connections)
+	}
+
+	if len(longRunning) != 2 {
+		t.Fatal()
+	}
+	if longRunning[0]["query.id"] != "13" || longRunning[0]["query.db"] != "2017" || longRunning[0]["query.timeSeconds"] != 300 {
+		t.Error()
+	}
+	if longRunning[0]["query.text"] != "SELECT SUM(total) FROM sales WHERE year = ?" || longRunning[1]["query.text"] != "SELECT * FROM orders WHERE customer = ?" {
+		t.Error()
+	}
+
+	if len(lockWaits) != 1 {
+		t.Fatal()
+	}
+	if lockWaits[0]["lockWait.waitingPid"] != "14" || lockWaits[0]["lockWait.blockingPid"] != "12" || lockWaits[0]["lockWait.waitAgeSeconds"] != 12 {
+		t.Error()
+	}
+	if lockWaits[0]["lockWait.waitingQuery"] != "UPDATE orders SET paid = ? WHERE id = ?" || lockWaits[0]["lockWait.blockingQuery"] != "" {
+		t.Error()
+	}
+}
+
+func TestPopulateProcesslistMetricsWithoutSys(t *testing.T) {
+	db := fakeDataSource{
+		errors: map[string]error{lockWaitsQuery: fmt.Errorf("Error 1146: Table 'sys.innodb_lock_waits' doesn't exist")},
+	}
+
+	integration := &sdk.Integration{}
+	sample := integration.NewMetricSet("MysqlSample")
+	if err := populateProcesslistMetrics(integration, sample, db); err != nil {
+		t.Fatal(err)
+	}
+	if (*sample)["db.longRunningQueries"] != 0 {
+		t.Error()
+	}
+	if _, ok := (*sample)["db.innodb.lockWaits"]; ok {
+		t.Error()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+var replicaMetrics = map[string][]interface{}{
+	"replica.channel":             {"channel", metric.ATTRIBUTE},
+	"replica.masterHost":          {"Master_Host", metric.ATTRIBUTE},
+	"replica.masterPort":          {"master_port", metric.ATTRIBUTE},
+	"replica.secondsBehindMaster": {"Seconds_Behind_Master", metric.GAUGE},
+	"replica.ioThreadState":       {"Slave_IO_Running", metric.ATTRIBUTE},
+	"replica.ioThreadRunning":     {"io_running", metric.GAUGE},
+	"replica.sqlThreadState":      {"Slave_SQL_Running", metric.ATTRIBUTE},
+	"replica.sqlThreadRunning":    {"sql_running", metric.GAUGE},
+	"replica.lastIoErrno":         {"Last_IO_Errno", metric.GAUGE},
+	"replica.lastIoError":         {"Last_IO_Error", metric.ATTRIBUTE},
+	"replica.lastSqlErrno":        {"Last_SQL_Errno", metric.GAUGE},
+	"replica.lastSqlError":        {"Last_SQL_Error", metric.ATTRIBUTE},
+	"replica.relayLogSpaceBytes":  {"Relay_Log_Space", metric.GAUGE},
+	"replica.executedGtidSetSize": {executedGtidSetSize, metric.GAUGE},
+}
+
+// executedGtidSetSize counts the transactions of a GTID set like
+// "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:11,\n4E11FA47-...:1-27"
+func executedGtidSetSize(metrics map[string]interface{}) (float64, bool) {
+	gtidSet, ok := metrics["Executed_Gtid_Set"]
+	if !ok {
+		return 0, false
+	}
+
+	size := 0
+	for _, gtids := range strings.Split(fmt.Sprintf("%v", gtidSet), ",") {
+		intervals := strings.Split(strings.TrimSpace(gtids), ":")
+		// The first element is the server UUID
+		for _, interval := range intervals[1:] {
+			bounds := strings.SplitN(interval, "-", 2)
+			start, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, false
+			}
+			end := start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, false
+				}
+			}
+			size += end - start + 1
+		}
+	}
+	return float64(size), true
+}
+
+// threadRunning is 1 when the replication thread state is "Yes"
+func threadRunning(state interface{}) int {
+	if state == "Yes" {
+		return 1
+	}
+	return 0
+}
+
+// populateReplicaMetrics reports a MysqlReplicaSample per replication
+// channel, multi-source replicas returning a row of SHOW SLAVE STATUS for each.
+func populateReplicaMetrics(integration *sdk.Integration, replicas []row) {
+	for _, replica := range replicas {
+		// Channel_Name only exists from MySQL 5.7
+		replica["channel"], _ = replica.getString("Channel_Name")
+		if port, ok := replica.getString("Master_Port"); ok {
+			replica["master_port"] = port
+		}
+		replica["io_running"] = threadRunning(replica["Slave_IO_Running"])
+		replica["sql_running"] = threadRunning(replica["Slave_SQL_Running"])
+
+		sample := integration.NewMetricSet("MysqlReplicaSample")
+		populatePartialMetrics(sample, replica, replicaMetrics)
+	}
+}
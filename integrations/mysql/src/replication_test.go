@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+func TestExecutedGtidSetSize(t *testing.T) {
+	sets := map[string]float64{
+		"": 0,
+		"3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5":                                                   5,
+		"3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:11":                                                6,
+		"3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:11-18,\n4E11FA47-71CA-11E1-9E33-C80AA9429562:1-27": 40,
+	}
+	for set, expected := range sets {
+		size, ok := executedGtidSetSize(map[string]interface{}{"Executed_Gtid_Set": set})
+		if !ok || size != expected {
+			t.Errorf("For GTID set '%s', expected size: %f. Actual size: %f", set, expected, size)
+		}
+	}
+
+	if _, ok := executedGtidSetSize(map[string]interface{}{}); ok {
+		t.Error()
+	}
+	if _, ok := executedGtidSetSize(map[string]interface{}{"Executed_Gtid_Set": "uuid:a-b"}); ok {
+		t.Error()
+	}
+}
+
+func TestPopulateReplicaMetrics(t *testing.T) {
+	replicas := []row{
+		{
+			"Channel_Name":          "orders",
+			"Master_Host":           "10.0.0.1",
+			"Master_Port":           3306,
+			"Slave_IO_Running":      "Yes",
+			"Slave_SQL_Running":     "Yes",
+			"Seconds_Behind_Master": 4,
+			"Last_IO_Errno":         0,
+			"Last_IO_Error":         "",
+			"Last_SQL_Errno":        0,
+			"Last_SQL_Error":        "",
+			"Relay_Log_Space":       4096,
+			"Executed_Gtid_Set":     "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5",
+		},
+		{
+			"Channel_Name":      "billing",
+			"Master_Host":       "10.0.0.2",
+			"Master_Port":       3307,
+			"Slave_IO_Running":  "Connecting",
+			"Slave_SQL_Running": "Yes",
+			"Last_IO_Errno":     2003,
+			"Last_IO_Error":     "error connecting to master",
+			"Last_SQL_Errno":    0,
+			"Last_SQL_Error":    "",
+			"Relay_Log_Space":   1024,
+			"Executed_Gtid_Set": "",
+		},
+	}
+
+	integration := &sdk.Integration{}
+	populateReplicaMetrics(integration, replicas)
+	if len(integration.Metrics) != 2 {
+		t.Fatal()
+	}
+
+	orders := *integration.Metrics[0]
+	if orders["event_type"] != "MysqlReplicaSample" || orders["replica.channel"] != "orders" {
+		t.Error()
+	}
+	if orders["replica.masterHost"] != "10.0.0.1" || orders["replica.masterPort"] != "3306" {
+		t.Error()
+	}
+	if orders["replica.secondsBehindMaster"] != 4 || orders["replica.relayLogSpaceBytes"] != 4096 {
+		t.Error()
+	}
+	if orders["replica.ioThreadRunning"] != 1 || orders["replica.sqlThreadRunning"] != 1 {
+		t.Error()
+	}
+	if orders["replica.executedGtidSetSize"] != float64(5) {
+		t.Error()
+	}
+
+	// Seconds_Behind_Master is NULL while the IO thread is down
+	billing := *integration.Metrics[1]
+	if billing["replica.channel"] != "billing" || billing["replica.secondsBehindMaster"] != nil {
+		t.Error()
+	}
+	if billing["replica.ioThreadRunning"] != 0 || billing["replica.ioThreadState"] != "Connecting" {
+		t.Error()
+	}
+	if billing["replica.lastIoErrno"] != 2003 || billing["replica.lastIoError"] != "error connecting to master" {
+		t.Error()
+	}
+}
+
+func TestGetRawDataNodeType(t *testing.T) {
+	database := fakeDataSource{
+		rows: map[string][]row{replicaQuery: {{"Slave_IO_Running": "Yes"}}},
+	}
+	_, metrics, err := getRawData(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas, ok := metrics["replicas"].([]row); metrics["node_type"] != "slave" || !ok || len(replicas) != 1 {
+		t.Error()
+	}
+}
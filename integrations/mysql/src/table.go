@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+const (
+	// The largest tables come first, so they are the ones kept by the limit
+	tablesQuery = `SELECT TABLE_SCHEMA, TABLE_NAME, ENGINE, TABLE_ROWS, DATA_LENGTH,
+INDEX_LENGTH, DATA_FREE, AUTO_INCREMENT
+FROM information_schema.TABLES
+WHERE TABLE_TYPE = 'BASE TABLE'
+AND TABLE_SCHEMA NOT IN ('mysql', 'information_schema', 'performance_schema', 'sys')
+ORDER BY DATA_LENGTH + INDEX_LENGTH DESC`
+	autoIncrementColumnsQuery = `SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_TYPE
+FROM information_schema.COLUMNS
+WHERE EXTRA LIKE '%auto_increment%' AND (`
+)
+
+// tablesQueryTimeout bounds the time spent reading each of the table queries
+const tablesQueryTimeout = 10 * time.Second
+
+var tableMetrics = map[string][]interface{}{
+	"table.schema":           {"TABLE_SCHEMA", metric.ATTRIBUTE},
+	"table.name":             {"TABLE_NAME", metric.ATTRIBUTE},
+	"table.engine":           {"ENGINE", metric.ATTRIBUTE},
+	"table.rows":             {"TABLE_ROWS", metric.GAUGE},
+	"table.dataLengthBytes":  {"DATA_LENGTH", metric.GAUGE},
+	"table.indexLengthBytes": {"INDEX_LENGTH", metric.GAUGE},
+	"table.dataFreeBytes":    {"DATA_FREE", metric.GAUGE},
+}
+
+// tableAutoIncrementMetrics are only reported for tables with an
+// AUTO_INCREMENT column
+var tableAutoIncrementMetrics = map[string][]interface{}{
+	"table.autoIncrement":            {"AUTO_INCREMENT", metric.GAUGE},
+	"table.autoIncrementHeadroom":    {autoIncrementHeadroom, metric.GAUGE},
+	"table.autoIncrementUsedPercent": {autoIncrementUsedPercent, metric.GAUGE},
+}
+
+// Largest value of the signed integer types, the unsigned ones doubling it
+var integerTypeMax = map[string]float64{
+	"tinyint":   math.MaxInt8,
+	"smallint":  math.MaxInt16,
+	"mediumint": 1<<23 - 1,
+	"int":       math.MaxInt32,
+	"integer":   math.MaxInt32,
+	"bigint":    math.MaxInt64,
+}
+
+var columnTypeRegex = regexp.MustCompile(`^(\w+)(\(\d+\))?( unsigned)?`)
+
+// columnTypeMax returns the largest value of an integer column type like
+// "int(10) unsigned"
+func columnTypeMax(columnType string) (float64, bool) {
+	match := columnTypeRegex.FindStringSubmatch(strings.ToLower(columnType))
+	if match == nil {
+		return 0, false
+	}
+	max, ok := integerTypeMax[match[1]]
+	if !ok {
+		return 0, false
+	}
+	if match[3] != "" {
+		max = max*2 + 1
+	}
+	return max, true
+}
+
+func autoIncrementValues(metrics map[string]interface{}) (float64, float64, bool) {
+	value, ok := row(metrics).getFloat("AUTO_INCREMENT")
+	if !ok {
+		return 0, 0, false
+	}
+	columnType, _ := row(metrics).getString("AUTO_INCREMENT_TYPE")
+	max, ok := columnTypeMax(columnType)
+	return value, max, ok
+}
+
+func autoIncrementHeadroom(metrics map[string]interface{}) (float64, bool) {
+	value, max, ok := autoIncrementValues(metrics)
+	return max - value, ok
+}
+
+func autoIncrementUsedPercent(metrics map[string]interface{}) (float64, bool) {
+	value, max, ok := autoIncrementValues(metrics)
+	return value / max * 100, ok
+}
+
+// tableFilter selects the "schema.table" names matching include and not
+// matching exclude, up to limit tables unless limit is 0.
+type tableFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	limit   int
+}
+
+func newTableFilter(include string, exclude string, limit int) (*tableFilter, error) {
+	filter := &tableFilter{limit: limit}
+	var err error
+	if include != "" {
+		if filter.include, err = regexp.Compile(include); err != nil {
+			return nil, fmt.Errorf("Invalid table include regex: %s", err)
+		}
+	}
+	if exclude != "" {
+		if filter.exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, fmt.Errorf("Invalid table exclude regex: %s", err)
+		}
+	}
+	return filter, nil
+}
+
+// query returns the tables query, limited in SQL when no regex filters the
+// tables afterwards
+func (f *tableFilter) query() string {
+	if f.include == nil && f.exclude == nil && f.limit > 0 {
+		return fmt.Sprintf("%s\nLIMIT %d", tablesQuery, f.limit)
+	}
+	return tablesQuery
+}
+
+func (f *tableFilter) match(name string) bool {
+	if f.include != nil && !f.include.MatchString(name) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(name)
+}
+
+// quoteString returns a SQL string literal
+func quoteString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(value) + "'"
+}
+
+// autoIncrementTypesQuery returns the query of the AUTO_INCREMENT column type
+// of the tables, nothing if no table has one
+func autoIncrementTypesQuery(tables []row) string {
+	var conditions bytes.Buffer
+	for _, table := range tables {
+		if _, ok := table["AUTO_INCREMENT"]; !ok {
+			continue
+		}
+		if conditions.Len() > 0 {
+			conditions.WriteString("\nOR ")
+		}
+		fmt.Fprintf(&conditions, "(TABLE_SCHEMA = %s AND TABLE_NAME = %s)",
+			quoteString(table["TABLE_SCHEMA"].(string)), quoteString(table["TABLE_NAME"].(string)))
+	}
+	if conditions.Len() == 0 {
+		return ""
+	}
+	return autoIncrementColumnsQuery + conditions.String() + ")"
+}
+
+// setAutoIncrementTypes sets the AUTO_INCREMENT_TYPE of the tables with an
+// AUTO_INCREMENT column, looking up only the given tables.
+func setAutoIncrementTypes(db dataSource, tables []row) {
+	query := autoIncrementTypesQuery(tables)
+	if query == "" {
+		return
+	}
+	columns, err := queryRowsWithTimeout(db, query, tablesQueryTimeout)
+	if err != nil {
+		log.Warn("Can't get the AUTO_INCREMENT columns from information_schema: %s", err)
+		return
+	}
+
+	types := make(map[string]string, len(columns))
+	for _, column := range columns {
+		schema, _ := column.getString("TABLE_SCHEMA")
+		name, _ := column.getString("TABLE_NAME")
+		types[schema+"."+name], _ = column.getString("COLUMN_TYPE")
+	}
+	for _, table := range tables {
+		if columnType, ok := types[table["TABLE_SCHEMA"].(string)+"."+table["TABLE_NAME"].(string)]; ok {
+			table["AUTO_INCREMENT_TYPE"] = columnType
+		}
+	}
+}
+
+// populateTableMetrics reports a MysqlTableSample with the size of the
+// largest tables of the user schemas.
+func populateTableMetrics(integration *sdk.Integration, db dataSource) error {
+	filter, err := newTableFilter(args.TableMetricsInclude, args.TableMetricsExclude, args.TableMetricsLimit)
+	if err != nil {
+		return err
+	}
+	tables, err := queryRowsWithTimeout(db, filter.query(), tablesQueryTimeout)
+	if err != nil {
+		return err
+	}
+
+	reported := make([]row, 0)
+	for _, table := range tables {
+		schema, _ := table.getString("TABLE_SCHEMA")
+		name, _ := table.getString("TABLE_NAME")
+		if !filter.match(schema + "." + name) {
+			continue
+		}
+		if filter.limit > 0 && len(reported) == filter.limit {
+			break
+		}
+		// Names like "2017" are scanned as numbers
+		table["TABLE_SCHEMA"], table["TABLE_NAME"] = schema, name
+		reported = append(reported, table)
+	}
+
+	setAutoIncrementTypes(db, reported)
+	for _, table := range reported {
+		sample := integration.NewMetricSet("MysqlTableSample")
+		populatePartialMetrics(sample, table, tableMetrics)
+		if _, ok := table["AUTO_INCREMENT_TYPE"]; ok {
+			populatePartialMetrics(sample, table, tableAutoIncrementMetrics)
+		}
+	}
+	return nil
+}
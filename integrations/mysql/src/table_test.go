@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+func TestColumnTypeMax(t *testing.T) {
+	types := map[string]float64{
+		"tinyint(4)":          127,
+		"tinyint(3) unsigned": 255,
+		"mediumint(8)":        8388607,
+		"int(11)":             2147483647,
+		"int(10) unsigned":    4294967295,
+		"int unsigned":        4294967295,
+		"BIGINT(20)":          math.MaxInt64,
+		"bigint unsigned":     math.MaxUint64,
+	}
+	for columnType, expected := range types {
+		if max, ok := columnTypeMax(columnType); !ok || max != expected {
+			t.Errorf("For type '%s', expected max: %f. Actual max: %f", columnType, expected, max)
+		}
+	}
+	if _, ok := columnTypeMax("varchar(255)"); ok {
+		t.Error()
+	}
+}
+
+func TestTableFilter(t *testing.T) {
+	filter, err := newTableFilter(`^app\.`, `\.tmp_`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.match("app.orders") || filter.match("app.tmp_orders") || filter.match("crm.users") {
+		t.Error()
+	}
+	if _, err = newTableFilter("(", "", 0); err == nil {
+		t.Error()
+	}
+	if _, err = newTableFilter("", "[", 0); err == nil {
+		t.Error()
+	}
+}
+
+func TestPopulateTableMetrics(t *testing.T) {
+	db := fakeDataSource{
+		rows: map[string][]row{tablesQuery: {
+			{"TABLE_SCHEMA": "app", "TABLE_NAME": "orders", "ENGINE": "InnoDB", "TABLE_ROWS": 1000, "DATA_LENGTH": 163840, "INDEX_LENGTH": 32768, "DATA_FREE": 4096, "AUTO_INCREMENT": 1073741824},
+			{"TABLE_SCHEMA": "app", "TABLE_NAME": "tmp_orders", "ENGINE": "InnoDB", "TABLE_ROWS": 100, "DATA_LENGTH": 16384, "INDEX_LENGTH": 0, "DATA_FREE": 0},
+			{"TABLE_SCHEMA": 2017, "TABLE_NAME": "events", "ENGINE": "MyISAM", "TABLE_ROWS": 10, "DATA_LENGTH": 1024, "INDEX_LENGTH": 1024, "DATA_FREE": 0},
+			{"TABLE_SCHEMA": "app", "TABLE_NAME": "users", "ENGINE": "InnoDB", "TABLE_ROWS": 5, "DATA_LENGTH": 16384, "INDEX_LENGTH": 0, "DATA_FREE": 0, "AUTO_INCREMENT": 6},
+		}},
+	}
+	// Only the reported tables are looked up
+	db.rows[autoIncrementColumnsQuery+"(TABLE_SCHEMA = 'app' AND TABLE_NAME = 'orders'))"] = []row{
+		{"TABLE_SCHEMA": "app", "TABLE_NAME": "orders", "COLUMN_TYPE": "int(11)"},
+	}
+
+	args = argumentList{TableMetricsExclude: `\.tmp_`, TableMetricsLimit: 2}
+	defer func() { args = argumentList{} }()
+
+	integration := &sdk.Integration{}
+	if err := populateTableMetrics(integration, db); err != nil {
+		t.Fatal(err)
+	}
+	if len(integration.Metrics) != 2 {
+		t.Fatal()
+	}
+
+	orders := *integration.Metrics[0]
+	if orders["event_type"] != "MysqlTableSample" || orders["table.schema"] != "app" || orders["table.name"] != "orders" {
+		t.Error()
+	}
+	if orders["table.dataLengthBytes"] != 163840 || orders["table.indexLengthBytes"] != 32768 || orders["table.rows"] != 1000 {
+		t.Error()
+	}
+	if orders["table.autoIncrementHeadroom"] != float64(1073741823) || orders["table.autoIncrementUsedPercent"] != 1073741824/float64(math.MaxInt32)*100 {
+		t.Error()
+	}
+
+	events := *integration.Metrics[1]
+	if events["table.schema"] != "2017" || events["table.engine"] != "MyISAM" {
+		t.Error()
+	}
+	if _, ok := events["table.autoIncrement"]; ok {
+		t.Error()
+	}
+
+	args.TableMetricsLimit = 0
+	integration = &sdk.Integration{}
+	if err := populateTableMetrics(integration, db); err != nil {
+		t.Fatal(err)
+	}
+	if len(integration.Metrics) != 3 {
+		t.Error()
+	}
+	// No AUTO_INCREMENT column is found for users
+	if _, ok := (*integration.Metrics[2])["table.autoIncrement"]; ok {
+		t.Error()
+	}
+}
+
+func TestPopulateTableMetricsLimitedInSQL(t *testing.T) {
+	db := fakeDataSource{
+		rows: map[string][]row{
+			tablesQuery + "\nLIMIT 1": {
+				{"TABLE_SCHEMA": "app", "TABLE_NAME": "o'rders", "ENGINE": "InnoDB", "TABLE_ROWS": 1000, "DATA_LENGTH": 163840, "INDEX_LENGTH": 32768, "DATA_FREE": 4096, "AUTO_INCREMENT": 10},
+			},
+			autoIncrementColumnsQuery + "(TABLE_SCHEMA = 'app' AND TABLE_NAME = 'o''rders'))": {
+				{"TABLE_SCHEMA": "app", "TABLE_NAME": "o'rders", "COLUMN_TYPE": "tinyint(4)"},
+			},
+		},
+	}
+
+	args = argumentList{TableMetricsLimit: 1}
+	defer func() { args = argumentList{} }()
+
+	integration := &sdk.Integration{}
+	if err := populateTableMetrics(integration, db); err != nil {
+		t.Fatal(err)
+	}
+	if len(integration.Metrics) != 1 {
+		t.Fatal()
+	}
+	if (*integration.Metrics[0])["table.autoIncrementHeadroom"] != float64(117) {
+		t.Error()
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/newrelic/infra-integrations-sdk/cache"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+// maxAccessLogBytes bounds the amount of log read in a single run. Anything
+// beyond it is left for the next run.
+const maxAccessLogBytes = 64 * 1024 * 1024
+
+// combinedLogFormat is predefined by NGINX and used by access_log when no
+// format is given
+const combinedLogFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+
+// defaultServerName groups the requests of access logs not bound to a server
+const defaultServerName = "_"
+
+var accessLogDefinition = map[string][]interface{}{
+	"server.name":                        {"server_name", metric.ATTRIBUTE},
+	"net.requestsPerSecond":              {"requests", metric.GAUGE},
+	"net.responses2xxPerSecond":          {"responses.2xx", metric.GAUGE},
+	"net.responses3xxPerSecond":          {"responses.3xx", metric.GAUGE},
+	"net.responses4xxPerSecond":          {"responses.4xx", metric.GAUGE},
+	"net.responses5xxPerSecond":          {"responses.5xx", metric.GAUGE},
+	"net.bytesSentPerSecond":             {"bytes_sent", metric.GAUGE},
+	"net.requestTimeP50Seconds":          {"request_time.p50", metric.GAUGE},
+	"net.requestTimeP95Seconds":          {"request_time.p95", metric.GAUGE},
+	"net.requestTimeP99Seconds":          {"request_time.p99", metric.GAUGE},
+	"net.upstreamResponseTimeP50Seconds": {"upstream_response_time.p50", metric.GAUGE},
+	"net.upstreamResponseTimeP95Seconds": {"upstream_response_time.p95", metric.GAUGE},
+	"net.upstreamResponseTimeP99Seconds": {"upstream_response_time.p99", metric.GAUGE},
+}
+
+var logVariableRegex = regexp.MustCompile(`\$(\w+)|\$\{(\w+)\}`)
+
+// logFormat matches the lines written by a log_format definition
+type logFormat struct {
+	regex     *regexp.Regexp
+	variables []string
+}
+
+// accessLog is a file written by access_log directives of the configuration
+type accessLog struct {
+	path       string
+	format     *logFormat
+	serverName string
+}
+
+// accessLogStats accumulates the requests of a server_name during a run
+type accessLogStats struct {
+	requests      float64
+	statuses      map[string]float64
+	bytesSent     float64
+	requestTimes  []float64
+	upstreamTimes []float64
+}
+
+func newAccessLogStats() *accessLogStats {
+	return &accessLogStats{statuses: make(map[string]float64)}
+}
+
+// compileLogFormat turns a log_format string into a regular expression with
+// a capture group per variable.
+func compileLogFormat(format string) (*logFormat, error) {
+	var expr bytes.Buffer
+	variables := make([]string, 0)
+
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range logVariableRegex.FindAllStringSubmatchIndex(format, -1) {
+		expr.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		var name string
+		if loc[2] >= 0 {
+			name = format[loc[2]:loc[3]]
+		} else {
+			name = format[loc[4]:loc[5]]
+		}
+		variables = append(variables, name)
+		expr.WriteString("(.*?)")
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(format[last:]))
+	expr.WriteString("$")
+
+	regex, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid log format '%s': %s", format, err)
+	}
+	return &logFormat{regex, variables}, nil
+}
+
+// parse returns the variables of a log line, or nil if it doesn't match
+func (format *logFormat) parse(line string) map[string]string {
+	match := format.regex.FindStringSubmatch(line)
+	if match == nil {
+		return nil
+	}
+	values := make(map[string]string, len(format.variables))
+	for i, name := range format.variables {
+		values[name] = match[i+1]
+	}
+	return values
+}
+
+// splitQuoted splits a directive value in words, removing the quotes around
+// them.
+func splitQuoted(value string) []string {
+	words := make([]string, 0)
+	var word bytes.Buffer
+	var quote rune
+	inWord := false
+
+	for _, r := range value {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// getLogFormats returns the log_format definitions found in the inventory,
+// including the predefined combined format.
+func getLogFormats(inventory sdk.Inventory) map[string]string {
+	formats := map[string]string{"combined": combinedLogFormat}
+
+	for key, item := range inventory {
+		if _, name := splitInventoryKey(key); name != "log_format" {
+			continue
+		}
+		words := splitQuoted(fmt.Sprintf("%v", item["value"]))
+		if len(words) < 2 {
+			continue
+		}
+		parts := make([]string, 0, len(words)-1)
+		for _, word := range words[1:] {
+			if !strings.HasPrefix(word, "escape=") {
+				parts = append(parts, word)
+			}
+		}
+		formats[words[0]] = strings.Join(parts, "")
+	}
+	return formats
+}
+
+// splitInventoryKey returns the context of an inventory item and the name of
+// its directive, without the index of repeated directives.
+func splitInventoryKey(key string) (string, string) {
+	prefix, name := "", key
+	if idx := strings.LastIndex(key, "/"); idx >= 0 {
+		prefix, name = key[:idx+1], key[idx+1:]
+	}
+	if idx := strings.Index(name, "["); idx >= 0 {
+		name = name[:idx]
+	}
+	return prefix, name
+}
+
+// getAccessLogs returns the files written by the access_log directives found
+// in the inventory with a known format. A file shared by several directives
+// is returned once, parsed with the format of the first one, and its lines
+// fall back to the default server name unless every directive belongs to the
+// same server_name. Relative paths are resolved against dir, the
+// configuration prefix.
+func getAccessLogs(inventory sdk.Inventory, dir string) []accessLog {
+	formats := getLogFormats(inventory)
+	compiled := make(map[string]*logFormat)
+	logs := make([]accessLog, 0)
+	byPath := make(map[string]int)
+
+	keys := make([]string, 0, len(inventory))
+	for key := range inventory {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		prefix, name := splitInventoryKey(key)
+		if name != "access_log" {
+			continue
+		}
+		words := splitQuoted(fmt.Sprintf("%v", inventory[key]["value"]))
+		if len(words) == 0 || words[0] == "off" || strings.HasPrefix(words[0], "syslog:") {
+			continue
+		}
+		if !filepath.IsAbs(words[0]) {
+			words[0] = filepath.Join(dir, words[0])
+		}
+
+		formatName := "combined"
+		if len(words) > 1 && !strings.Contains(words[1], "=") {
+			formatName = words[1]
+		}
+		format, ok := compiled[formatName]
+		if !ok {
+			raw, found := formats[formatName]
+			if !found {
+				log.Warn("Unknown log format %s for access log %s", formatName, words[0])
+				continue
+			}
+			var err error
+			if format, err = compileLogFormat(raw); err != nil {
+				log.Warn("%s", err)
+				continue
+			}
+			compiled[formatName] = format
+		}
+
+		serverName := defaultServerName
+		for _, serverNameKey := range []string{prefix + "server_name", prefix + "server_name[0]"} {
+			if item, ok := inventory[serverNameKey]; ok {
+				if names := splitQuoted(fmt.Sprintf("%v", item["value"])); len(names) > 0 {
+					serverName = names[0]
+				}
+			}
+		}
+
+		if i, ok := byPath[words[0]]; ok {
+			if logs[i].format != format {
+				log.Warn("Access log %s is written with several formats, only the first one is parsed", words[0])
+			}
+			if logs[i].serverName != serverName {
+				logs[i].serverName = defaultServerName
+			}
+			continue
+		}
+		byPath[words[0]] = len(logs)
+		logs = append(logs, accessLog{words[0], format, serverName})
+	}
+	return logs
+}
+
+// fileInode returns the inode of a file, which changes when the log is
+// rotated
+func fileInode(info os.FileInfo) float64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return float64(stat.Ino)
+	}
+	return 0
+}
+
+// tailAccessLog reads the lines appended to the log since the previous run,
+// whose offset is kept in the cache, and adds them to the stats of every
+// server_name. Counters are converted to rates over the time elapsed since the
+// previous run. On the first run, or when the cache has expired, the log is
+// only positioned at its end. A log whose inode changed, or that shrank, was
+// rotated and is read from the start.
+func tailAccessLog(accessLog accessLog, stats map[string]*accessLogStats) error {
+	f, err := os.Open(accessLog.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	offsetKey := fmt.Sprintf("accessLog/%s/offset", accessLog.path)
+	inodeKey := fmt.Sprintf("accessLog/%s/inode", accessLog.path)
+	inode := fileInode(info)
+	oldInode, _, inodeFound := cache.Get(inodeKey)
+	cache.Set(inodeKey, inode)
+
+	oldOffset, oldTime, ok := cache.Get(offsetKey)
+	if !ok {
+		cache.Set(offsetKey, float64(info.Size()))
+		return nil
+	}
+	offset := int64(oldOffset)
+	if (inodeFound && oldInode != inode) || info.Size() < offset {
+		// The log was rotated or truncated
+		offset = 0
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	runStats := make(map[string]*accessLogStats)
+	reader := bufio.NewReader(io.LimitReader(f, maxAccessLogBytes))
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// Partial lines are read again on the next run
+			break
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		addLogLine(accessLog, strings.TrimRight(line, "\r\n"), runStats)
+	}
+
+	newTime := cache.Set(offsetKey, float64(offset))
+	elapsed := float64(newTime - oldTime)
+	if elapsed <= 0 {
+		return fmt.Errorf("Samples for %s are too close in time, skipping sampling", accessLog.path)
+	}
+
+	for name, run := range runStats {
+		total, ok := stats[name]
+		if !ok {
+			total = newAccessLogStats()
+			stats[name] = total
+		}
+		total.requests += run.requests / elapsed
+		total.bytesSent += run.bytesSent / elapsed
+		for class, count := range run.statuses {
+			total.statuses[class] += count / elapsed
+		}
+		total.requestTimes = append(total.requestTimes, run.requestTimes...)
+		total.upstreamTimes = append(total.upstreamTimes, run.upstreamTimes...)
+	}
+	return nil
+}
+
+func addLogLine(accessLog accessLog, line string, stats map[string]*accessLogStats) {
+	values := accessLog.format.parse(line)
+	if values == nil {
+		log.Debug("Unexpected line in %s: %s", accessLog.path, line)
+		return
+	}
+
+	serverName := accessLog.serverName
+	if name := values["server_name"]; name != "" && name != "-" {
+		serverName = name
+	} else if host := values["host"]; host != "" && host != "-" {
+		serverName = host
+	}
+	server, ok := stats[serverName]
+	if !ok {
+		server = newAccessLogStats()
+		stats[serverName] = server
+	}
+
+	server.requests++
+	if status := values["status"]; len(status) == 3 {
+		server.statuses[status[:1]+"xx"]++
+	}
+	if bytes, err := strconv.ParseFloat(values["bytes_sent"], 64); err == nil {
+		server.bytesSent += bytes
+	} else if bytes, err := strconv.ParseFloat(values["body_bytes_sent"], 64); err == nil {
+		server.bytesSent += bytes
+	}
+	if requestTime, err := strconv.ParseFloat(values["request_time"], 64); err == nil {
+		server.requestTimes = append(server.requestTimes, requestTime)
+	}
+	if upstreamTime, ok := upstreamResponseTime(values["upstream_response_time"]); ok {
+		server.upstreamTimes = append(server.upstreamTimes, upstreamTime)
+	}
+}
+
+// upstreamResponseTime adds up the times of every upstream server contacted
+// for a request, which NGINX separates with commas and colons.
+func upstreamResponseTime(value string) (float64, bool) {
+	total := 0.0
+	found := false
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ':' || r == ' ' }) {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			continue
+		}
+		total += v
+		found = true
+	}
+	return total, found
+}
+
+// percentile returns the nearest-rank percentile p of sorted values
+func percentile(values []float64, p float64) float64 {
+	rank := int(math.Ceil(p/100*float64(len(values)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return values[rank]
+}
+
+func (stats *accessLogStats) rawMetrics(serverName string) map[string]interface{} {
+	metrics := map[string]interface{}{
+		"server_name": serverName,
+		"requests":    stats.requests,
+		"bytes_sent":  stats.bytesSent,
+	}
+	for _, class := range []string{"2xx", "3xx", "4xx", "5xx"} {
+		metrics["responses."+class] = stats.statuses[class]
+	}
+
+	timings := map[string][]float64{
+		"request_time":           stats.requestTimes,
+		"upstream_response_time": stats.upstreamTimes,
+	}
+	for name, values := range timings {
+		if len(values) == 0 {
+			continue
+		}
+		sort.Float64s(values)
+		metrics[name+".p50"] = percentile(values, 50)
+		metrics[name+".p95"] = percentile(values, 95)
+		metrics[name+".p99"] = percentile(values, 99)
+	}
+	return metrics
+}
+
+// setAccessLogMetrics tails the access logs named in the configuration file
+// and reports a NginxAccessLogSample per server_name.
+func setAccessLogMetrics(integration *sdk.Integration) error {
+	directives, err := readConfig()
+	if err != nil {
+		return err
+	}
+	// Only the configuration is needed, not the certificates
+	inventory := make(sdk.Inventory)
+	populateConfigInventory(directives, inventory)
+
+	stats := make(map[string]*accessLogStats)
+	for _, accessLog := range getAccessLogs(inventory, filepath.Dir(args.ConfigPath)) {
+		if err := tailAccessLog(accessLog, stats); err != nil {
+			log.Warn("Can't read access log %s: %s", accessLog.path, err)
+		}
+	}
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sample := integration.NewMetricSet("NginxAccessLogSample")
+		populateMetrics(sample, stats[name].rawMetrics(name), accessLogDefinition)
+	}
+	return nil
+}
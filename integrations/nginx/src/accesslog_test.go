@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/cache"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+var testNginxAccessLogConf = `http {
+    log_format timed '$remote_addr [$time_local] "$request" $status $body_bytes_sent '
+                     '$host $request_time $upstream_response_time';
+    access_log /var/log/nginx/access.log;
+
+    server {
+        server_name www.example.com example.com;
+        access_log /var/log/nginx/www.access.log timed buffer=32k;
+    }
+}
+`
+
+var testNginxAccessLogLines = `10.0.0.1 [24/Mar/2017:10:11:45 +0000] "GET / HTTP/1.1" 200 612 www.example.com 0.010 0.008
+10.0.0.1 [24/Mar/2017:10:11:46 +0000] "GET /login HTTP/1.1" 302 0 www.example.com 0.020 0.004, 0.012
+10.0.0.2 [24/Mar/2017:10:11:46 +0000] "GET /missing HTTP/1.1" 404 150 www.example.com 0.001 -
+10.0.0.3 [24/Mar/2017:10:11:47 +0000] "POST /api HTTP/1.1" 502 166 api.example.com 1.500 1.500
+this line doesn't match the format
+10.0.0.3 [24/Mar/2017:10:11:47 +0000] "GET /api HTTP/1.1" 200 10 api.example.com 0.5`
+
+func TestCompileLogFormat(t *testing.T) {
+	format, err := compileLogFormat(`$remote_addr - ${remote_user} [$time_local] "$request" $status`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := format.parse(`127.0.0.1 - bob [24/Mar/2017:10:11:45 +0000] "GET / HTTP/1.1" 200`)
+	if values == nil {
+		t.Fatal()
+	}
+	if values["remote_user"] != "bob" || values["request"] != "GET / HTTP/1.1" || values["status"] != "200" {
+		t.Error()
+	}
+	if format.parse("garbage") != nil {
+		t.Error()
+	}
+}
+
+func TestGetAccessLogs(t *testing.T) {
+	directives, err := parseConfig(bufio.NewReader(strings.NewReader(testNginxAccessLogConf)), "nginx.conf", ".", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory := make(sdk.Inventory)
+	populateInventory(directives, inventory)
+
+	logs := getAccessLogs(inventory, "/etc/nginx")
+	if len(logs) != 2 {
+		t.Fatal()
+	}
+	if logs[0].path != "/var/log/nginx/access.log" || logs[0].serverName != defaultServerName {
+		t.Error()
+	}
+	if logs[1].path != "/var/log/nginx/www.access.log" || logs[1].serverName != "www.example.com" {
+		t.Error()
+	}
+	if logs[1].format.parse(strings.Split(testNginxAccessLogLines, "\n")[0]) == nil {
+		t.Error()
+	}
+}
+
+func TestGetAccessLogsSharedFile(t *testing.T) {
+	directives, err := parseConfig(bufio.NewReader(strings.NewReader(`http {
+    server {
+        server_name www.example.com;
+        access_log /var/log/nginx/access.log;
+    }
+    server {
+        server_name api.example.com;
+        access_log /var/log/nginx/access.log;
+    }
+}
+`)), "nginx.conf", ".", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory := make(sdk.Inventory)
+	populateInventory(directives, inventory)
+
+	logs := getAccessLogs(inventory, "/etc/nginx")
+	if len(logs) != 1 {
+		t.Fatalf("Expected 1 access log, got %d", len(logs))
+	}
+	if logs[0].path != "/var/log/nginx/access.log" || logs[0].serverName != defaultServerName {
+		t.Error()
+	}
+}
+
+func TestSetAccessLogMetricsWithRelativePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.Mkdir(filepath.Join(dir, "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := "http {\n    access_log logs/access.log;\n}\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "nginx.conf"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "logs/access.log")
+	if err = ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args = argumentList{ConfigPath: filepath.Join(dir, "nginx.conf")}
+	defer func() { args = argumentList{} }()
+	now := time.Now()
+	cache.SetNow(func() time.Time { return now })
+	defer cache.SetNow(time.Now)
+
+	if err = setAccessLogMetrics(&sdk.Integration{}); err != nil {
+		t.Fatal(err)
+	}
+	line := `10.0.0.1 - - [24/Mar/2017:10:11:45 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/7.54.0"` + "\n"
+	if err = ioutil.WriteFile(path, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(10 * time.Second)
+	integration := &sdk.Integration{}
+	if err = setAccessLogMetrics(integration); err != nil {
+		t.Fatal(err)
+	}
+	if len(integration.Metrics) != 1 || (*integration.Metrics[0])["net.requestsPerSecond"] != 0.1 {
+		t.Errorf("Expected the requests of %s, got %v", path, integration.Metrics)
+	}
+}
+
+func TestTailAccessLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	if err = ioutil.WriteFile(path, []byte("old line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	format, err := compileLogFormat(`$remote_addr [$time_local] "$request" $status $body_bytes_sent $host $request_time $upstream_response_time`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessLog := accessLog{path, format, defaultServerName}
+
+	now := time.Now()
+	cache.SetNow(func() time.Time { return now })
+	defer cache.SetNow(time.Now)
+
+	// The first run only positions the log at its end
+	stats := make(map[string]*accessLogStats)
+	if err = tailAccessLog(accessLog, stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 0 {
+		t.Fatal()
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(testNginxAccessLogLines)
+	f.Close()
+
+	now = now.Add(10 * time.Second)
+	if err = tailAccessLog(accessLog, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	www, ok := stats["www.example.com"]
+	if !ok {
+		t.Fatal()
+	}
+	if www.requests != 0.3 || www.statuses["4xx"] != 0.1 || www.bytesSent != 76.2 {
+		t.Error()
+	}
+	metrics := www.rawMetrics("www.example.com")
+	if metrics["request_time.p50"] != 0.01 || metrics["request_time.p99"] != 0.02 {
+		t.Error()
+	}
+	if metrics["upstream_response_time.p95"] != 0.016 {
+		t.Error()
+	}
+	if stats["api.example.com"].statuses["5xx"] != 0.1 {
+		t.Error()
+	}
+
+	// The partial last line is kept for the next run
+	offset, _, _ := cache.Get("accessLog/" + path + "/offset")
+	if int(offset) != len("old line\n")+strings.LastIndex(testNginxAccessLogLines, "\n")+1 {
+		t.Error()
+	}
+}
+
+func TestTailAccessLogRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	if err = ioutil.WriteFile(path, []byte("old line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	format, err := compileLogFormat(`$remote_addr [$time_local] "$request" $status $body_bytes_sent $host $request_time $upstream_response_time`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessLog := accessLog{path, format, defaultServerName}
+
+	now := time.Now()
+	cache.SetNow(func() time.Time { return now })
+	defer cache.SetNow(time.Now)
+
+	stats := make(map[string]*accessLogStats)
+	if err = tailAccessLog(accessLog, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new log is already bigger than the offset of the rotated one
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, []byte(testNginxAccessLogLines+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(10 * time.Second)
+	if err = tailAccessLog(accessLog, stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["www.example.com"] == nil || stats["www.example.com"].requests != 0.3 || stats["api.example.com"].requests != 0.1 {
+		t.Error()
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+var certificateDefinition = map[string][]interface{}{
+	"vhost.name":                       {"vhost", metric.ATTRIBUTE},
+	"certificate.path":                 {"path", metric.ATTRIBUTE},
+	"certificate.subject":              {"subject", metric.ATTRIBUTE},
+	"certificate.subjectAltNames":      {"sans", metric.ATTRIBUTE},
+	"certificate.issuer":               {"issuer", metric.ATTRIBUTE},
+	"certificate.notAfter":             {"not_after", metric.ATTRIBUTE},
+	"certificate.keyType":              {"key_type", metric.ATTRIBUTE},
+	"certificate.daysUntilExpiry":      {"days_until_expiry", metric.GAUGE},
+	"certificate.chainLength":          {"chain_length", metric.GAUGE},
+	"certificate.chainDaysUntilExpiry": {"chain_days_until_expiry", metric.GAUGE},
+}
+
+// certificate is a PEM file referenced by an ssl_certificate directive. The
+// first certificate of the file is the server one, followed by its chain.
+type certificate struct {
+	path  string
+	leaf  *x509.Certificate
+	chain []*x509.Certificate
+}
+
+// vhostCertificate is a certificate served by a vhost
+type vhostCertificate struct {
+	vhost *vhost
+	cert  *certificate
+}
+
+// loadCertificate reads every certificate of a PEM file
+func loadCertificate(path string) (*certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid certificate in %s: %s", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("No certificate found in %s", path)
+	}
+	return &certificate{path, certs[0], certs[1:]}, nil
+}
+
+// certificatePaths returns the files of the ssl_certificate directives of a
+// block. Certificates given as variables or inline data can't be loaded.
+func certificatePaths(block []*directive, dir string) []string {
+	paths := make([]string, 0)
+	for _, d := range findDirectives(block, "ssl_certificate") {
+		if len(d.args) == 0 {
+			continue
+		}
+		path := unquote(d.args[0])
+		if strings.Contains(path, "$") || strings.HasPrefix(path, "data:") || strings.HasPrefix(path, "engine:") {
+			log.Debug("Skipping certificate %s", path)
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// isSSLVhost tells whether a vhost listens with TLS enabled
+func isSSLVhost(v *vhost) bool {
+	for _, listen := range v.listen {
+		for _, param := range strings.Fields(listen)[1:] {
+			if param == "ssl" {
+				return true
+			}
+		}
+	}
+	for _, d := range findDirectives(v.server.block, "ssl") {
+		if d.value() == "on" {
+			return true
+		}
+	}
+	return false
+}
+
+// getCertificates loads the certificates of every vhost listening with TLS.
+// Server blocks without ssl_certificate inherit the ones of the http context.
+// Relative paths are resolved against dir, the configuration prefix.
+func getCertificates(directives []*directive, dir string) []vhostCertificate {
+	inherited := make([]string, 0)
+	for _, http := range findDirectives(directives, "http") {
+		inherited = append(inherited, certificatePaths(http.block, dir)...)
+	}
+
+	loaded := make(map[string]*certificate)
+	certs := make([]vhostCertificate, 0)
+	for _, v := range getVhosts(directives) {
+		if !isSSLVhost(v) {
+			continue
+		}
+		paths := inherited
+		if len(findDirectives(v.server.block, "ssl_certificate")) > 0 {
+			paths = certificatePaths(v.server.block, dir)
+		}
+
+		for _, path := range paths {
+			cert, ok := loaded[path]
+			if !ok {
+				var err error
+				if cert, err = loadCertificate(path); err != nil {
+					log.Warn("Can't load certificate of %s: %s", v.key(), err)
+					continue
+				}
+				loaded[path] = cert
+			}
+			certs = append(certs, vhostCertificate{v, cert})
+		}
+	}
+	return certs
+}
+
+// keyType describes the public key of a certificate, e.g. "RSA 2048"
+func keyType(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+func daysUntil(t time.Time) int {
+	return int(t.Sub(time.Now()).Hours() / 24)
+}
+
+func (cert *certificate) rawMetrics() map[string]interface{} {
+	metrics := map[string]interface{}{
+		"path":              cert.path,
+		"subject":           cert.leaf.Subject.CommonName,
+		"sans":              strings.Join(cert.leaf.DNSNames, ","),
+		"issuer":            cert.leaf.Issuer.CommonName,
+		"not_after":         cert.leaf.NotAfter.UTC().Format(time.RFC3339),
+		"key_type":          keyType(cert.leaf),
+		"days_until_expiry": daysUntil(cert.leaf.NotAfter),
+		"chain_length":      len(cert.chain),
+	}
+	if len(cert.chain) > 0 {
+		notAfter := cert.chain[0].NotAfter
+		for _, c := range cert.chain[1:] {
+			if c.NotAfter.Before(notAfter) {
+				notAfter = c.NotAfter
+			}
+		}
+		metrics["chain_days_until_expiry"] = daysUntil(notAfter)
+	}
+	return metrics
+}
+
+func populateCertificateInventory(certs []vhostCertificate, inventory sdk.Inventory) {
+	for _, vc := range certs {
+		key := fmt.Sprintf("%s/certificate/%s", vc.vhost.key(), keySegment(vc.cert.path))
+		for field, value := range vc.cert.rawMetrics() {
+			if field == "days_until_expiry" || field == "chain_days_until_expiry" {
+				continue
+			}
+			inventory.SetItem(key, field, fmt.Sprintf("%v", value))
+		}
+	}
+}
+
+// setCertificateMetrics reports a NginxCertificateSample per certificate of
+// every vhost in the configuration file.
+func setCertificateMetrics(integration *sdk.Integration) error {
+	directives, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	for _, vc := range getCertificates(directives, filepath.Dir(args.ConfigPath)) {
+		metrics := vc.cert.rawMetrics()
+		metrics["vhost"] = vc.vhost.label()
+
+		sample := integration.NewMetricSet("NginxCertificateSample")
+		populateMetrics(sample, metrics, certificateDefinition)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/newrelic/infra-integrations/pkg/httpclient"
+)
+
+// newHTTPClient returns the client used to query the status endpoints, as
+// configured in the arguments.
+func newHTTPClient() (*http.Client, error) {
+	headers, err := httpclient.HeadersFromJSON(args.Headers.Get())
+	if err != nil {
+		return nil, err
+	}
+
+	return httpclient.New(httpclient.Options{
+		CABundle:           args.CABundle,
+		ClientCert:         args.ClientCert,
+		ClientKey:          args.ClientKey,
+		InsecureSkipVerify: args.TLSInsecureSkipVerify,
+		Username:           args.Username,
+		Password:           args.Password,
+		Headers:            headers,
+		UnixSocket:         args.UnixSocket,
+		Timeout:            time.Duration(args.Timeout) * time.Second,
+	})
+}
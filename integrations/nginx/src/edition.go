@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/cache"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+)
+
+const (
+	editionPlus       = "plus"
+	editionOpenSource = "open source"
+)
+
+// How the edition and the version were detected
+const (
+	sourceContentType = "content-type"
+	sourceBody        = "body"
+	sourceStatus      = "status"
+	sourceServer      = "server-header"
+	sourceAPI         = "api"
+	sourceBinary      = "binary"
+	sourceUnknown     = "unknown"
+)
+
+var metricsEditionDefinition = map[string][]interface{}{
+	"software.editionSource": {"edition_source", metric.ATTRIBUTE},
+	"software.versionSource": {"version_source", metric.ATTRIBUTE},
+}
+
+// apiProbeInterval is how long an NGINX without the Plus API isn't probed
+// again for the version
+const apiProbeInterval = time.Hour
+
+var nginxVersionRegex = regexp.MustCompile(`nginx/([0-9][^\s;,)]*)`)
+
+// detectStatusEdition tells whether a status response is the NGINX Plus JSON
+// document or the stub status page. The media type of the response is used
+// when it's conclusive, otherwise the body is sniffed.
+func detectStatusEdition(header http.Header, body []byte) (string, string, error) {
+	if mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+		if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+			return editionPlus, sourceContentType, nil
+		}
+	}
+
+	var document map[string]interface{}
+	if json.Unmarshal(body, &document) == nil {
+		return editionPlus, sourceBody, nil
+	}
+	if bytes.Contains(body, []byte("Active connections")) {
+		return editionOpenSource, sourceBody, nil
+	}
+	return "", "", fmt.Errorf("Can't recognize the status of %s", args.StatusURL)
+}
+
+// versionFromServerHeader returns the version in a "nginx/1.13.4" Server
+// header, which is hidden by `server_tokens off`.
+func versionFromServerHeader(header http.Header) string {
+	if match := nginxVersionRegex.FindStringSubmatch(header.Get("Server")); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// probeAPIVersion asks the NGINX Plus REST API for the version. A failed
+// probe is remembered in the cache, so that an NGINX without the API, which
+// answers every probe with a 404, is only probed again after
+// apiProbeInterval.
+func probeAPIVersion(client *http.Client) (string, error) {
+	failedKey := fmt.Sprintf("plusAPI/%s/probeFailed", args.StatusURL)
+	if failedAt, _, ok := cache.Get(failedKey); ok && time.Since(time.Unix(int64(failedAt), 0)) < apiProbeInterval {
+		return "", fmt.Errorf("No NGINX Plus API found since %s", time.Unix(int64(failedAt), 0))
+	}
+
+	version, err := getAPIVersion(client)
+	if err != nil {
+		cache.Set(failedKey, float64(time.Now().Unix()))
+	}
+	return version, err
+}
+
+// getAPIVersion reads the version from the nginx endpoint of the API
+func getAPIVersion(client *http.Client) (string, error) {
+	apiRoot, err := plusAPIRoot(args.StatusURL)
+	if err != nil {
+		return "", err
+	}
+	version, err := getPlusAPIVersion(client, apiRoot)
+	if err != nil {
+		return "", err
+	}
+	var nginx map[string]interface{}
+	if err = getJSON(client, fmt.Sprintf("%s%d/nginx", apiRoot, version), &nginx); err != nil {
+		return "", err
+	}
+	if v, ok := nginx["version"].(string); ok && v != "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("No version in the NGINX Plus API")
+}
+
+// probeBinaryVersion runs `nginx -v`, which prints e.g.
+// "nginx version: nginx/1.13.4 (nginx-plus-r13)", and returns the version
+// and edition.
+func probeBinaryVersion(binary string) (string, string, error) {
+	output, err := exec.Command(binary, "-v").CombinedOutput()
+	if err != nil {
+		return "", "", fmt.Errorf("Can't run %s -v: %s", binary, err)
+	}
+	match := nginxVersionRegex.FindSubmatch(output)
+	if match == nil {
+		return "", "", fmt.Errorf("Unexpected output of %s -v: %s", binary, strings.TrimSpace(string(output)))
+	}
+	edition := editionOpenSource
+	if bytes.Contains(output, []byte("nginx-plus")) {
+		edition = editionPlus
+	}
+	return string(match[1]), edition, nil
+}
+
+// detectStandardVersion finds the version of an NGINX serving the stub
+// status page. The Server header is used when it has the version, otherwise
+// the Plus API is probed and then the nginx binary when configured. Both
+// probes also reveal a Plus edition serving stub status.
+func detectStandardVersion(client *http.Client, header http.Header, rawMetrics map[string]interface{}) {
+	if version := versionFromServerHeader(header); version != "" {
+		rawMetrics["version"] = version
+		rawMetrics["version_source"] = sourceServer
+		return
+	}
+
+	if version, err := probeAPIVersion(client); err == nil {
+		rawMetrics["version"] = version
+		rawMetrics["version_source"] = sourceAPI
+		rawMetrics["edition"] = editionPlus
+		rawMetrics["edition_source"] = sourceAPI
+		return
+	}
+
+	if args.NginxBinary != "" {
+		version, edition, err := probeBinaryVersion(args.NginxBinary)
+		if err == nil {
+			rawMetrics["version"] = version
+			rawMetrics["version_source"] = sourceBinary
+			if edition == editionPlus {
+				rawMetrics["edition"] = editionPlus
+				rawMetrics["edition_source"] = sourceBinary
+			}
+			return
+		}
+		log.Warn("%s", err)
+	}
+
+	rawMetrics["version"] = ""
+	rawMetrics["version_source"] = sourceUnknown
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+func TestDetectStatusEdition(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        string
+		edition     string
+		source      string
+	}{
+		{"application/json", testNginxPlusStatus, editionPlus, sourceContentType},
+		{"application/json; charset=utf-8", testNginxPlusStatus, editionPlus, sourceContentType},
+		{"text/plain", testNginxPlusStatus, editionPlus, sourceBody},
+		{"", testNginxStandardStatus, editionOpenSource, sourceBody},
+		{"text/plain; charset=utf-8", testTengineStandardStatus, editionOpenSource, sourceBody},
+	}
+	for _, c := range cases {
+		header := http.Header{"Content-Type": {c.contentType}}
+		edition, source, err := detectStatusEdition(header, []byte(c.body))
+		if err != nil || edition != c.edition || source != c.source {
+			t.Error()
+		}
+	}
+
+	if _, _, err := detectStatusEdition(http.Header{"Content-Type": {"text/html"}}, []byte(testBadNginxStandardStatus)); err == nil {
+		t.Error()
+	}
+}
+
+func TestVersionFromServerHeader(t *testing.T) {
+	headers := map[string]string{
+		"nginx/1.13.4":                    "1.13.4",
+		"nginx/1.11.10 (Ubuntu)":          "1.11.10",
+		"nginx":                           "",
+		"openresty/1.13.6.1":              "",
+		"Tengine/2.2.0 (nginx/1.8.1-dev)": "1.8.1-dev",
+	}
+	for server, version := range headers {
+		if versionFromServerHeader(http.Header{"Server": {server}}) != version {
+			t.Error()
+		}
+	}
+}
+
+func TestProbeBinaryVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "nginx")
+	script := "#!/bin/sh\necho 'nginx version: nginx/1.13.4 (nginx-plus-r13)' >&2\n"
+	if err = ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	version, edition, err := probeBinaryVersion(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "1.13.4" || edition != editionPlus {
+		t.Error()
+	}
+
+	if _, _, err = probeBinaryVersion(filepath.Join(dir, "missing")); err == nil {
+		t.Error()
+	}
+}
+
+func TestGetMetricsDataWithHiddenVersion(t *testing.T) {
+	routes := map[string]string{"/status": testNginxStandardStatus}
+	for path, body := range testNginxPlusAPI {
+		routes[path] = body
+	}
+	apiRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			apiRequests++
+		}
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// server_tokens off
+		w.Header().Set("Server", "nginx")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	args = argumentList{StatusURL: server.URL + "/status"}
+	defer func() { args = argumentList{} }()
+
+	integration := &sdk.Integration{}
+	sample := integration.NewMetricSet("NginxSample")
+	if err := getMetricsData(integration, sample); err != nil {
+		t.Fatal(err)
+	}
+	if (*sample)["software.version"] != "1.13.4" || (*sample)["software.versionSource"] != sourceAPI {
+		t.Error()
+	}
+	if (*sample)["software.edition"] != editionPlus || (*sample)["software.editionSource"] != sourceAPI {
+		t.Error()
+	}
+
+	// Without the API the version remains unknown
+	delete(routes, "/api/")
+	integration = &sdk.Integration{}
+	sample = integration.NewMetricSet("NginxSample")
+	if err := getMetricsData(integration, sample); err != nil {
+		t.Fatal(err)
+	}
+	if (*sample)["software.edition"] != editionOpenSource || (*sample)["software.editionSource"] != sourceBody {
+		t.Error()
+	}
+	if (*sample)["software.version"] != "" || (*sample)["software.versionSource"] != sourceUnknown {
+		t.Error()
+	}
+
+	// The missing API isn't probed again on the next run
+	apiRequests = 0
+	integration = &sdk.Integration{}
+	sample = integration.NewMetricSet("NginxSample")
+	if err := getMetricsData(integration, sample); err != nil {
+		t.Fatal(err)
+	}
+	if apiRequests != 0 || (*sample)["software.versionSource"] != sourceUnknown {
+		t.Errorf("Expected no API request, got %d", apiRequests)
+	}
+}
@@ -2,99 +2,402 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/newrelic/infra-integrations-sdk/sdk"
 )
 
-<<<<<<< HEAD
-func populateInventory(reader *bufio.Reader, inventory map[string]sdk.Inventory) error {
-=======
-func populateInventory(reader *bufio.Reader, inventory sdk.Inventory) error {
->>>>>>> upstream/master
-	var curCmd string
-	var curValue string
+// maxIncludeDepth protects against include loops in the configuration files
+const maxIncludeDepth = 10
 
-	prefix := make([]string, 0, 10)
-	lineNo := 1
+// directive is a simple or block directive of the configuration
+type directive struct {
+	name   string
+	args   []string
+	line   int
+	column int
+	block  []*directive
+}
+
+// value returns the arguments of the directive as written in the file
+func (d *directive) value() string {
+	return strings.Join(d.args, " ")
+}
+
+type tokenType int
+
+const (
+	tokenWord tokenType = iota
+	tokenSemicolon
+	tokenBlockStart
+	tokenBlockEnd
+	tokenEOF
+)
+
+// token is a word or a special character of the configuration. The text of
+// quoted words keeps the quotes, while value has them removed.
+type token struct {
+	kind   tokenType
+	text   string
+	value  string
+	line   int
+	column int
+}
+
+// configLexer splits an NGINX configuration file in tokens
+type configLexer struct {
+	reader   *bufio.Reader
+	filename string
+	line     int
+	column   int
+	last     int
+}
+
+func newConfigLexer(reader *bufio.Reader, filename string) *configLexer {
+	return &configLexer{reader: reader, filename: filename, line: 1}
+}
+
+func (lexer *configLexer) errorf(line int, column int, format string, a ...interface{}) error {
+	return fmt.Errorf("Error parsing %s in Line %d, Column %d: %s", lexer.filename, line, column, fmt.Sprintf(format, a...))
+}
 
+func (lexer *configLexer) read() (rune, error) {
+	r, _, err := lexer.reader.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	lexer.last = lexer.column
+	if r == '\n' {
+		lexer.line++
+		lexer.column = 0
+	} else {
+		lexer.column++
+	}
+	return r, nil
+}
+
+func (lexer *configLexer) unread(r rune) {
+	lexer.reader.UnreadRune()
+	if r == '\n' {
+		lexer.line--
+	}
+	lexer.column = lexer.last
+}
+
+func (lexer *configLexer) next() (token, error) {
+	var r rune
+	var err error
+
+	// skip blanks and comments
 	for {
-		r, _, err := reader.ReadRune()
-		if err == io.EOF {
-			return nil
-		}
-
-		switch r {
-		case '{':
-			// parse start section
-			if curValue != "" {
-				curValue = strings.Replace(curValue, "/", ":", -1)
-				prefix = append(prefix, fmt.Sprintf("%s:%s", curCmd, strings.Trim(curValue, " \t")))
-			} else {
-				prefix = append(prefix, curCmd)
+		if r, err = lexer.read(); err != nil {
+			if err == io.EOF {
+				return token{kind: tokenEOF, line: lexer.line, column: lexer.column}, nil
 			}
-			curCmd = ""
-			curValue = ""
-		case '}':
-			// parse end section
-			closeIdx := len(prefix) - 1
-			if closeIdx < 0 {
-				return fmt.Errorf("Error parsing config file in Line %d", lineNo)
+			return token{}, err
+		}
+		if r == '#' {
+			for r != '\n' {
+				if r, err = lexer.read(); err != nil {
+					break
+				}
 			}
-			prefix = prefix[:closeIdx]
-		case ';':
-			// parse end statement
-			prefix = append(prefix, curCmd)
-<<<<<<< HEAD
-			inventory[strings.Join(prefix, "/")] = sdk.Inventory{"value": curValue}
-=======
-			inventory.SetItem(strings.Join(prefix, "/"), "value", curValue)
->>>>>>> upstream/master
-			prefix = prefix[:len(prefix)-1]
-
-			curValue = ""
-			curCmd = ""
-		case '\n':
-			// parse end line and ignore spaces
-			for r == '\n' || r == ' ' || r == '\t' {
-				r, _, _ = reader.ReadRune()
+			continue
+		}
+		if r != ' ' && r != '\t' && r != '\r' && r != '\n' {
+			break
+		}
+	}
+
+	line, column := lexer.line, lexer.column
+	switch r {
+	case ';':
+		return token{tokenSemicolon, ";", ";", line, column}, nil
+	case '{':
+		return token{tokenBlockStart, "{", "{", line, column}, nil
+	case '}':
+		return token{tokenBlockEnd, "}", "}", line, column}, nil
+	case '"', '\'':
+		return lexer.quoted(r, line, column)
+	}
+	lexer.unread(r)
+	return lexer.word(line, column)
+}
+
+// quoted reads a quoted word. Backslash escapes the closing quote.
+func (lexer *configLexer) quoted(quote rune, line int, column int) (token, error) {
+	var value bytes.Buffer
+	for {
+		r, err := lexer.read()
+		if err != nil {
+			if err == io.EOF {
+				return token{}, lexer.errorf(line, column, "unterminated quoted string")
 			}
-			lineNo++
-			reader.UnreadRune()
-		case '#':
-			// ignore comments
-			for r != '\n' {
-				r, _, _ = reader.ReadRune()
+			return token{}, err
+		}
+		if r == quote {
+			break
+		}
+		if r == '\\' {
+			next, err := lexer.read()
+			if err != nil {
+				return token{}, lexer.errorf(line, column, "unterminated quoted string")
 			}
-		case '\t', ' ':
-			if curValue == "" {
-				continue
+			if next != quote && next != '\\' {
+				value.WriteRune(r)
 			}
-			if curValue != "" && curCmd == "" {
-				curCmd = curValue
-				curValue = ""
-			} else {
-				curValue += string(r)
+			r = next
+		}
+		value.WriteRune(r)
+	}
+	text := string(quote) + strings.Replace(value.String(), string(quote), "\\"+string(quote), -1) + string(quote)
+	return token{tokenWord, text, value.String(), line, column}, nil
+}
+
+// word reads an unquoted word, which ends at a blank or a special character.
+// Variables like ${name} may contain braces.
+func (lexer *configLexer) word(line int, column int) (token, error) {
+	var value bytes.Buffer
+	var prev rune
+	for {
+		r, err := lexer.read()
+		if err != nil {
+			if err == io.EOF {
+				break
 			}
-		default:
-			curValue += string(r)
+			return token{}, err
 		}
+		if r == '{' && prev == '$' {
+			value.WriteRune(r)
+			for r != '}' {
+				if r, err = lexer.read(); err != nil {
+					return token{}, lexer.errorf(line, column, "unterminated variable")
+				}
+				value.WriteRune(r)
+			}
+			prev = r
+			continue
+		}
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' || r == ';' || r == '{' || r == '}' {
+			lexer.unread(r)
+			break
+		}
+		if r == '\\' {
+			next, err := lexer.read()
+			if err == nil {
+				value.WriteRune(r)
+				r = next
+			}
+		}
+		value.WriteRune(r)
+		prev = r
 	}
+	return token{tokenWord, value.String(), value.String(), line, column}, nil
+}
+
+// configParser builds the directives of a configuration file, following its
+// include directives.
+type configParser struct {
+	lexer *configLexer
+	dir   string
+	depth int
+}
+
+// parseConfig reads an NGINX configuration from reader. Relative `include`
+// patterns are resolved against dir, the configuration prefix.
+func parseConfig(reader *bufio.Reader, filename string, dir string, depth int) ([]*directive, error) {
+	parser := &configParser{newConfigLexer(reader, filename), dir, depth}
+	return parser.parseBlock(nil)
+}
+
+// parseBlock reads directives until the end of the block started by parent,
+// or the end of the file for the main context.
+func (parser *configParser) parseBlock(parent *token) ([]*directive, error) {
+	directives := make([]*directive, 0)
+	lexer := parser.lexer
+
+	for {
+		tok, err := lexer.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok.kind {
+		case tokenEOF:
+			if parent != nil {
+				return nil, lexer.errorf(parent.line, parent.column, "unexpected end of file, expecting \"}\"")
+			}
+			return directives, nil
+		case tokenBlockEnd:
+			if parent == nil {
+				return nil, lexer.errorf(tok.line, tok.column, "unexpected \"}\"")
+			}
+			return directives, nil
+		case tokenSemicolon, tokenBlockStart:
+			return nil, lexer.errorf(tok.line, tok.column, "unexpected \"%s\"", tok.text)
+		}
+
+		d := &directive{name: tok.value, args: make([]string, 0), line: tok.line, column: tok.column}
+	args:
+		for {
+			arg, err := lexer.next()
+			if err != nil {
+				return nil, err
+			}
+			switch arg.kind {
+			case tokenWord:
+				d.args = append(d.args, arg.text)
+			case tokenSemicolon:
+				break args
+			case tokenBlockStart:
+				if d.block, err = parser.parseBlock(&tok); err != nil {
+					return nil, err
+				}
+				break args
+			case tokenBlockEnd:
+				return nil, lexer.errorf(arg.line, arg.column, "unexpected \"}\"")
+			case tokenEOF:
+				return nil, lexer.errorf(tok.line, tok.column, "unexpected end of file, expecting \";\" or \"}\"")
+			}
+		}
+		directives = append(directives, d)
+
+		if d.name == "include" && d.block == nil {
+			included, err := parser.include(d)
+			if err != nil {
+				return nil, err
+			}
+			directives = append(directives, included...)
+		}
+	}
+}
+
+// include parses every file matching the glob pattern of an include
+// directive, in lexical order as NGINX does.
+func (parser *configParser) include(d *directive) ([]*directive, error) {
+	if len(d.args) != 1 {
+		return nil, parser.lexer.errorf(d.line, d.column, "invalid number of arguments in \"include\"")
+	}
+	if parser.depth >= maxIncludeDepth {
+		return nil, parser.lexer.errorf(d.line, d.column, "too many nested includes")
+	}
+
+	pattern := unquote(d.args[0])
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(parser.dir, pattern)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, parser.lexer.errorf(d.line, d.column, "%s", err)
+	}
+	sort.Strings(paths)
+
+	directives := make([]*directive, 0)
+	for _, path := range paths {
+		included, err := readConfigFile(path, parser.dir, parser.depth+1)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, included...)
+	}
+	return directives, nil
+}
+
+// unquote removes the quotes around a directive argument
+func unquote(arg string) string {
+	if len(arg) >= 2 && (arg[0] == '"' || arg[0] == '\'') && arg[len(arg)-1] == arg[0] {
+		return strings.Replace(arg[1:len(arg)-1], "\\"+arg[:1], arg[:1], -1)
+	}
+	return arg
+}
+
+func readConfigFile(path string, dir string, depth int) ([]*directive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseConfig(bufio.NewReader(f), path, dir, depth)
+}
+
+// keySegment escapes a value used in an inventory key, like a path, whose
+// slashes would otherwise add segments to the key
+func keySegment(value string) string {
+	return strings.Replace(value, "/", ":", -1)
+}
+
+// directiveKey returns the inventory path segment of a directive. Blocks
+// include their arguments, e.g. "location:=::50x.html".
+func directiveKey(d *directive) string {
+	if d.block == nil || len(d.args) == 0 {
+		return d.name
+	}
+	args := make([]string, len(d.args))
+	for i, arg := range d.args {
+		args[i] = unquote(arg)
+	}
+	return fmt.Sprintf("%s:%s", d.name, keySegment(strings.Join(args, " ")))
+}
+
+// populateInventory adds an item per directive. Directives repeated in the
+// same context, like listen or several server blocks, are indexed in the
+// order they appear (e.g. "http/server[1]/listen[0]").
+func populateInventory(directives []*directive, inventory sdk.Inventory) {
+	populateBlockInventory("", directives, inventory)
+}
+
+func populateBlockInventory(prefix string, directives []*directive, inventory sdk.Inventory) {
+	counts := make(map[string]int)
+	for _, d := range directives {
+		counts[directiveKey(d)]++
+	}
+
+	seen := make(map[string]int)
+	for _, d := range directives {
+		key := directiveKey(d)
+		if counts[key] > 1 {
+			key = fmt.Sprintf("%s[%d]", key, seen[key])
+			seen[directiveKey(d)]++
+		}
+		key = prefix + key
+
+		if d.block != nil {
+			populateBlockInventory(key+"/", d.block, inventory)
+			continue
+		}
+		inventory.SetItem(key, "value", d.value())
+	}
+}
+
+// readConfig parses the configuration file given in the arguments
+func readConfig() ([]*directive, error) {
+	return readConfigFile(args.ConfigPath, filepath.Dir(args.ConfigPath), 0)
+}
+
+// populateConfigInventory reports the directives and vhosts of the
+// configuration, which the access log metrics rely on too.
+func populateConfigInventory(directives []*directive, inventory sdk.Inventory) {
+	populateInventory(directives, inventory)
+	populateVhostInventory(directives, inventory)
 }
 
-<<<<<<< HEAD
-func getInventoryData(inventory map[string]sdk.Inventory) error {
-=======
 func setInventoryData(inventory sdk.Inventory) error {
->>>>>>> upstream/master
-	f, err := os.Open(args.ConfigPath)
+	directives, err := readConfig()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	return populateInventory(bufio.NewReader(f), inventory)
+	populateConfigInventory(directives, inventory)
+	// Reading every certificate is only worth it when they are monitored
+	if args.CertificateMetrics {
+		populateCertificateInventory(getCertificates(directives, filepath.Dir(args.ConfigPath)), inventory)
+	}
+	return nil
 }
@@ -2,6 +2,9 @@ package main
 
 import (
 	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -61,16 +64,12 @@ http {
 )
 
 func TestParseNginxConf(t *testing.T) {
-<<<<<<< HEAD
-	inventory := make(map[string]sdk.Inventory)
-=======
-	inventory := make(sdk.Inventory)
->>>>>>> upstream/master
-	err := populateInventory(bufio.NewReader(strings.NewReader(testNginxConf)), inventory)
-
+	directives, err := parseConfig(bufio.NewReader(strings.NewReader(testNginxConf)), "nginx.conf", ".", 0)
 	if err != nil {
-		t.Fatal()
+		t.Fatal(err)
 	}
+	inventory := make(sdk.Inventory)
+	populateInventory(directives, inventory)
 
 	if inventory["pid"]["value"] != "/run/nginx.pid" {
 		t.Error()
@@ -84,5 +83,121 @@ func TestParseNginxConf(t *testing.T) {
 	if inventory["http/server/location::status/allow"]["value"] != "192.168.100.0/24" {
 		t.Error()
 	}
+	if inventory["http/server/listen[0]"]["value"] != "80 default_server" {
+		t.Error()
+	}
+	if inventory["http/server/listen[1]"]["value"] != "[::]:80 default_server" {
+		t.Error()
+	}
+	if inventory["http/server/error_page[1]"]["value"] != "500 502 503 504 /50x.html" {
+		t.Error()
+	}
+	if _, ok := inventory["http/server/location:= :50x.html"]; ok {
+		t.Error()
+	}
+}
+
+func TestParseNginxConfWithQuotes(t *testing.T) {
+	conf := `http {
+    add_header Content-Security-Policy "default-src 'self'; img-src *";  # inline comment
+    return 200 'a # is not a comment; neither is this';
+    set $greeting "say \"hi\"";
+    rewrite ^/(.*)${suffix}$ /$1 last;
+}`
+	directives, err := parseConfig(bufio.NewReader(strings.NewReader(conf)), "nginx.conf", ".", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory := make(sdk.Inventory)
+	populateInventory(directives, inventory)
+
+	if inventory["http/add_header"]["value"] != `Content-Security-Policy "default-src 'self'; img-src *"` {
+		t.Error()
+	}
+	if inventory["http/return"]["value"] != `200 'a # is not a comment; neither is this'` {
+		t.Error()
+	}
+	if inventory["http/set"]["value"] != `$greeting "say \"hi\""` {
+		t.Error()
+	}
+	if inventory["http/rewrite"]["value"] != `^/(.*)${suffix}$ /$1 last` {
+		t.Error()
+	}
+}
+
+func TestParseNginxConfWithIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 
+	if err = os.Mkdir(filepath.Join(dir, "conf.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"nginx.conf":         "user nginx;\nhttp {\n  include conf.d/*.conf;\n}\n",
+		"conf.d/a.conf":      "server {\n  server_name a.example.com;\n}\n",
+		"conf.d/b.conf":      "server {\n  server_name b.example.com;\n}\n",
+		"conf.d/b.conf.save": "server {\n  server_name ignored.example.com;\n}\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	directives, err := readConfigFile(filepath.Join(dir, "nginx.conf"), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory := make(sdk.Inventory)
+	populateInventory(directives, inventory)
+
+	if inventory["http/include"]["value"] != "conf.d/*.conf" {
+		t.Error()
+	}
+	if inventory["http/server[0]/server_name"]["value"] != "a.example.com" {
+		t.Error()
+	}
+	if inventory["http/server[1]/server_name"]["value"] != "b.example.com" {
+		t.Error()
+	}
+	if _, ok := inventory["http/server[2]/server_name"]; ok {
+		t.Error()
+	}
+}
+
+func TestParseNginxConfWithIncludeLoop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nginx.conf")
+	if err = ioutil.WriteFile(path, []byte("include nginx.conf;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = readConfigFile(path, dir, 0); err == nil {
+		t.Error()
+	}
+}
+
+func TestParseBadNginxConf(t *testing.T) {
+	confs := map[string]string{
+		"events {\n  worker_connections 1024;\n}\n}\n":     "Line 4, Column 1: unexpected \"}\"",
+		"http {\n  server {\n  }\n":                        "Line 1, Column 1: unexpected end of file",
+		"user nginx":                                       "Line 1, Column 1: unexpected end of file",
+		"http {\n  return 200 'unterminated;\n}\n":         "Line 2, Column 14: unterminated quoted string",
+		"http {\n  ;\n}\n":                                 "Line 2, Column 3: unexpected \";\"",
+		"http {\n  location / {\n    deny all }\n  }\n}\n": "Line 3, Column 14: unexpected \"}\"",
+	}
+	for conf, expected := range confs {
+		_, err := parseConfig(bufio.NewReader(strings.NewReader(conf)), "nginx.conf", ".", 0)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Error()
+		}
+	}
 }
@@ -2,17 +2,18 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
+	"io/ioutil"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
 )
 
 var metricsPlusDefinition = map[string][]interface{}{
@@ -59,12 +60,34 @@ var metricsStandardDefinition = map[string][]interface{}{
 >>>>>>> upstream/master
 }
 
-// expressions contains the structure of the input data and defines the attributes we want to store
-var nginxStatusExpressions = []*regexp.Regexp{
-	regexp.MustCompile(`Active connections:\s+(?P<active>\d+)`),
-	nil,
-	regexp.MustCompile(`\s*(?P<accepted>\d+)\s+(?P<handled>\d+)\s+(?P<requests>\d+)`),
-	regexp.MustCompile(`Reading: (?P<reading>\d+)\s+Writing: (?P<writing>\d+)\s+Waiting: (?P<waiting>\d+)`),
+// statusValueRegex matches the "Name: value" pairs of the stub status page
+var statusValueRegex = regexp.MustCompile(`([A-Za-z][A-Za-z ]*?):\s*(\d+)`)
+
+// statusValueNames maps the names of the stub status pairs to metric keys
+var statusValueNames = map[string]string{
+	"active connections": "active",
+	"reading":            "reading",
+	"writing":            "writing",
+	"waiting":            "waiting",
+}
+
+// statusCounterNames maps the columns of the "server accepts handled
+// requests" header to metric keys. Tengine adds a request_time column.
+var statusCounterNames = map[string]string{
+	"accepts":      "accepted",
+	"handled":      "handled",
+	"requests":     "requests",
+	"request_time": "request_time",
+}
+
+// statusFields are the metrics every stub status page is expected to have
+var statusFields = []string{"active", "accepted", "handled", "requests", "reading", "writing", "waiting"}
+
+// maxStatusSize bounds the size of the status document read
+const maxStatusSize = 16 * 1024 * 1024
+
+var metricsStandardMissingDefinition = map[string][]interface{}{
+	"net.statusMissingFields": {"missing_fields", metric.ATTRIBUTE},
 }
 
 <<<<<<< HEAD
@@ -81,60 +104,115 @@ func connectionsDropped(metrics map[string]interface{}) (int, bool) {
 	return 0, false
 }
 
-// getMetrics reads an NGINX (open edition) status message and transforms its
-// contents into a map that can be processed by NR agent.
-// It returns a map of metrics with all the keys and values extracted from the
-// status endpoint.
+// getStandardMetrics reads an NGINX (open edition) status message and
+// transforms its contents into a map that can be processed by NR agent.
+// Every field is recognized wherever it appears, so extra lines added by
+// variants like Tengine or OpenResty are ignored. Missing fields are listed
+// in the "missing_fields" key, and an error is only returned when no field is
+// found at all.
 func getStandardMetrics(reader *bufio.Reader) (map[string]interface{}, error) {
 	metrics := make(map[string]interface{})
-	for lineNo, re := range nginxStatusExpressions {
-		line, err := reader.ReadString('\n')
+	counterNames := []string{"accepts", "handled", "requests"}
 
-		if err == io.EOF {
-			return metrics, nil
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
 		}
 
-		if re == nil {
-			continue
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) > 1 && fields[0] == "server":
+			counterNames = fields[1:]
+		case len(fields) > 0 && isNumericLine(fields):
+			for i, field := range fields {
+				if i >= len(counterNames) {
+					break
+				}
+				name, ok := statusCounterNames[counterNames[i]]
+				if !ok {
+					log.Debug("Unknown status column '%s'", counterNames[i])
+					continue
+				}
+				metrics[name], _ = strconv.Atoi(field)
+			}
+		default:
+			for _, match := range statusValueRegex.FindAllStringSubmatch(line, -1) {
+				name, ok := statusValueNames[strings.ToLower(strings.TrimSpace(match[1]))]
+				if !ok {
+					log.Debug("Unknown status field '%s'", match[1])
+					continue
+				}
+				metrics[name], _ = strconv.Atoi(match[2])
+			}
 		}
 
-		match := re.FindStringSubmatch(line)
-		if match == nil {
-			return nil, fmt.Errorf("Line %d of status doesn't match", lineNo)
+		if err == io.EOF {
+			break
 		}
+	}
 
-		for i, name := range re.SubexpNames() {
-			if i != 0 {
-				value, err := strconv.Atoi(match[i])
-				if err != nil {
-					log.Warn("Can't cast value '%s'", match[i])
-					continue
-				}
-				metrics[name] = value
-			}
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("No status fields found")
+	}
+
+	missing := make([]string, 0)
+	for _, name := range statusFields {
+		if _, ok := metrics[name]; !ok {
+			missing = append(missing, name)
 		}
 	}
-	metrics["version"] = ""
-	metrics["edition"] = "open source"
+	if len(missing) > 0 {
+		log.Warn("Status fields not found: %s", strings.Join(missing, ", "))
+		metrics["missing_fields"] = strings.Join(missing, ",")
+	}
 
+	metrics["version"] = ""
+	metrics["edition"] = editionOpenSource
 	return metrics, nil
 }
 
+// isNumericLine tells whether every field of a line is an integer
+func isNumericLine(fields []string) bool {
+	for _, field := range fields {
+		if _, err := strconv.Atoi(field); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
 // getPlusMetrics reads an NGINX (Plus edition) status message, gets some
 // metrics and transforms the contents into a map that can be processed by NR
 // agent.
 // It returns a map of metrics keys -> values.
 func getPlusMetrics(reader *bufio.Reader) (map[string]interface{}, error) {
+	metrics, _, err := getPlusStatus(reader)
+	return metrics, err
+}
+
+// getPlusStatus reads an NGINX (Plus edition) status message like
+// getPlusMetrics and also returns the server zones, upstreams, upstream peers
+// and caches it contains, each one of them to be reported on its own.
+func getPlusStatus(reader *bufio.Reader) (map[string]interface{}, []plusEntity, error) {
 	jsonMetrics := make(map[string]interface{})
-	metrics := make(map[string]interface{})
 
 	dec := json.NewDecoder(reader)
 	err := dec.Decode(&jsonMetrics)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	roots := [2]string{"connections", "requests"}
+	metrics, entities := plusMetricsFromJSON(jsonMetrics)
+	return metrics, entities, nil
+}
+
+// plusMetricsFromJSON extracts the metrics of a decoded NGINX Plus status
+// document, or of its equivalent rebuilt from the REST API.
+func plusMetricsFromJSON(jsonMetrics map[string]interface{}) (map[string]interface{}, []plusEntity) {
+	metrics := make(map[string]interface{})
+
+	roots := [3]string{"connections", "requests", "ssl"}
 
 	for _, rootKey := range roots {
 		rootNode, ok := jsonMetrics[rootKey].(map[string]interface{})
@@ -142,13 +220,12 @@ func getPlusMetrics(reader *bufio.Reader) (map[string]interface{}, error) {
 			log.Warn("Can't assert type for %s", rootNode)
 			continue
 		}
-		for key, value := range rootNode {
-			metrics[fmt.Sprintf("%s.%s", rootKey, key)] = int(value.(float64))
-		}
+		// Newer API versions nest objects like ssl.verify_failures
+		flattenJSONInto(rootKey, rootNode, metrics)
 	}
 	metrics["version"] = jsonMetrics["nginx_version"]
-	metrics["edition"] = "plus"
-	return metrics, nil
+	metrics["edition"] = editionPlus
+	return metrics, getPlusEntities(jsonMetrics)
 }
 
 func populateMetrics(sample *metric.MetricSet, metrics map[string]interface{}, metricsDefinition map[string][]interface{}) error {
@@ -187,30 +264,68 @@ func populateMetrics(sample *metric.MetricSet, metrics map[string]interface{}, m
 	return nil
 }
 
-func getMetricsData(sample *metric.MetricSet) error {
-	netClient := &http.Client{
-		Timeout: time.Second * 1,
+func getMetricsData(integration *sdk.Integration, sample *metric.MetricSet) error {
+	netClient, err := newHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	if args.PlusAPIDiscovery {
+		jsonMetrics, err := getPlusAPIStatus(netClient, args.StatusURL)
+		if err == nil {
+			rawMetrics, entities := plusMetricsFromJSON(jsonMetrics)
+			rawMetrics["edition_source"] = sourceAPI
+			rawMetrics["version_source"] = sourceAPI
+			populateMetrics(sample, rawMetrics, metricsEditionDefinition)
+			populateMetrics(sample, rawMetrics, metricsPlusSSLDefinition)
+			populatePlusEntities(integration, entities)
+			return populateMetrics(sample, rawMetrics, metricsPlusDefinition)
+		}
+		log.Warn("Can't use the NGINX Plus API, falling back to %s: %s", args.StatusURL, err)
 	}
+
 	resp, err := netClient.Get(args.StatusURL)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxStatusSize))
+	if err != nil {
+		return err
+	}
+
+	edition, editionSource, err := detectStatusEdition(resp.Header, body)
+	if err != nil {
+		return err
+	}
+
 	var rawMetrics map[string]interface{}
 	var metricsDefinition map[string][]interface{}
+	var entities []plusEntity
 
-	if resp.Header.Get("content-type") == "application/json" {
+	if edition == editionPlus {
 		metricsDefinition = metricsPlusDefinition
-		rawMetrics, err = getPlusMetrics(bufio.NewReader(resp.Body))
+		rawMetrics, entities, err = getPlusStatus(bufio.NewReader(bytes.NewReader(body)))
+		if err == nil {
+			rawMetrics["edition_source"] = editionSource
+			rawMetrics["version_source"] = sourceStatus
+			populateMetrics(sample, rawMetrics, metricsPlusSSLDefinition)
+		}
 	} else {
 		metricsDefinition = metricsStandardDefinition
-		rawMetrics, err = getStandardMetrics(bufio.NewReader(resp.Body))
-		rawVersion := strings.Replace(resp.Header.Get("Server"), "nginx/", "", -1)
-		rawMetrics["version"] = rawVersion
-
+		rawMetrics, err = getStandardMetrics(bufio.NewReader(bytes.NewReader(body)))
+		if err == nil {
+			rawMetrics["edition_source"] = editionSource
+			detectStandardVersion(netClient, resp.Header, rawMetrics)
+			if _, ok := rawMetrics["missing_fields"]; ok {
+				populateMetrics(sample, rawMetrics, metricsStandardMissingDefinition)
+			}
+		}
 	}
 	if err != nil {
 		return err
 	}
+	populateMetrics(sample, rawMetrics, metricsEditionDefinition)
+	populatePlusEntities(integration, entities)
 	return populateMetrics(sample, rawMetrics, metricsDefinition)
 }
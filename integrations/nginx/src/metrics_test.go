@@ -11,12 +11,20 @@ server accepts handled requests
  16630948 16630948 31070465
 Reading: 6 Writing: 179 Waiting: 106
 `
-var testBadNginxStandardStatus = `Active connections: 291
+var testExtraLineNginxStandardStatus = `Active connections: 291
 server accepts handled requests
-this is an extra line that makes the parser fail
+this is an extra line that used to make the parser fail
  16630948 16630948 31070465
 Reading: 6 Writing: 179 Waiting: 106
 `
+var testTengineStandardStatus = `Active connections: 1
+server accepts handled requests request_time
+ 1140 1140 1140 75806
+Reading: 0 Writing: 1 Waiting: 0
+`
+var testPartialNginxStandardStatus = `Active connections: 291
+Reading: 6 Writing: 179`
+var testBadNginxStandardStatus = `<html><body>404 Not Found</body></html>`
 
 var testNginxPlusStatus = `{
   "timestamp": 1490347905131,
@@ -109,6 +117,45 @@ func TestGetStandardMetrics(t *testing.T) {
 	}
 }
 
+func TestGetStandardMetricsWithExtraLines(t *testing.T) {
+	rawMetrics, err := getStandardMetrics(bufio.NewReader(strings.NewReader(testExtraLineNginxStandardStatus)))
+	if err != nil {
+		t.Fatal()
+	}
+	if len(rawMetrics) != 9 {
+		t.Error()
+	}
+	if rawMetrics["requests"] != 31070465 || rawMetrics["waiting"] != 106 {
+		t.Error()
+	}
+}
+
+func TestGetTengineStandardMetrics(t *testing.T) {
+	rawMetrics, err := getStandardMetrics(bufio.NewReader(strings.NewReader(testTengineStandardStatus)))
+	if err != nil {
+		t.Fatal()
+	}
+	if rawMetrics["requests"] != 1140 || rawMetrics["request_time"] != 75806 {
+		t.Error()
+	}
+	if _, ok := rawMetrics["missing_fields"]; ok {
+		t.Error()
+	}
+}
+
+func TestGetPartialStandardMetrics(t *testing.T) {
+	rawMetrics, err := getStandardMetrics(bufio.NewReader(strings.NewReader(testPartialNginxStandardStatus)))
+	if err != nil {
+		t.Fatal()
+	}
+	if rawMetrics["active"] != 291 || rawMetrics["writing"] != 179 {
+		t.Error()
+	}
+	if rawMetrics["missing_fields"] != "accepted,handled,requests,waiting" {
+		t.Error()
+	}
+}
+
 func TestGetStandardMetricsWithInvalidData(t *testing.T) {
 	rawMetrics, err := getStandardMetrics(bufio.NewReader(strings.NewReader(testBadNginxStandardStatus)))
 
@@ -8,8 +8,23 @@ import (
 
 type argumentList struct {
 	sdk_args.DefaultArgumentList
-	StatusURL  string `default:"http://127.0.0.1/status" help:"NGINX status URL."`
-	ConfigPath string `default:"/etc/nginx/nginx.conf" help:"NGINX configuration file."`
+	StatusURL             string        `default:"http://127.0.0.1/status" help:"NGINX status URL."`
+	ConfigPath            string        `default:"/etc/nginx/nginx.conf" help:"NGINX configuration file."`
+	PlusAPIDiscovery      bool          `default:"false" help:"Query the NGINX Plus REST API under /api/ of the status URL host, falling back to the status URL for older builds."`
+	AccessLogMetrics      bool          `default:"false" help:"Tail the access logs named in the configuration file to report status classes and latencies per server_name."`
+	CertificateMetrics    bool          `default:"false" help:"Report the expiry of the TLS certificates named in the configuration file."`
+	CABundle              string        `default:"" help:"PEM file with the CAs trusted to verify the status URL certificate."`
+	ClientCert            string        `default:"" help:"PEM client certificate sent to the status URL."`
+	ClientKey             string        `default:"" help:"PEM key of the client certificate."`
+	TLSInsecureSkipVerify bool          `default:"false" help:"Don't verify the status URL certificate."`
+	Username              string        `default:"" help:"Basic auth username for the status URL."`
+	Password              string        `default:"" help:"Basic auth password for the status URL."`
+	Headers               sdk_args.JSON `default:"" help:"JSON object with headers added to the status requests, e.g. {\"Host\": \"status.local\"}."`
+	UnixSocket            string        `default:"" help:"Unix socket to connect to instead of the status URL host."`
+	Timeout               int           `default:"1" help:"Timeout in seconds of the status requests."`
+	NginxBinary           string        `default:"" help:"NGINX binary run with -v to detect the version when the Server header hides it."`
+	ProcessMetrics        bool          `default:"false" help:"Report the resources used by the worker processes of the master in the pid directive."`
+	ProcPath              string        `default:"/proc" help:"Path of the proc filesystem, for agents running in a container."`
 }
 
 const (
@@ -45,7 +60,19 @@ func main() {
 	if args.All || args.Metrics {
 		sample := integration.NewMetricSet("NginxSample")
 >>>>>>> upstream/master
-		fatalIfErr(getMetricsData(sample))
+		fatalIfErr(getMetricsData(integration, sample))
+
+		if args.AccessLogMetrics {
+			fatalIfErr(setAccessLogMetrics(integration))
+		}
+
+		if args.CertificateMetrics {
+			fatalIfErr(setCertificateMetrics(integration))
+		}
+
+		if args.ProcessMetrics {
+			fatalIfErr(setProcessMetrics(integration, sample))
+		}
 	}
 
 	fatalIfErr(integration.Publish())
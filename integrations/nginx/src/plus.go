@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+	"github.com/newrelic/infra-integrations/pkg/sampler"
+)
+
+var metricsPlusSSLDefinition = map[string][]interface{}{
+	"net.sslHandshakesPerSecond":       {"ssl.handshakes", metric.RATE},
+	"net.sslHandshakesFailedPerSecond": {"ssl.handshakes_failed", metric.RATE},
+	"net.sslSessionReusesPerSecond":    {"ssl.session_reuses", metric.RATE},
+}
+
+var serverZoneDefinition = map[string][]interface{}{
+	"serverZone.name":                   {"name", metric.ATTRIBUTE},
+	"serverZone.processing":             {"processing", metric.GAUGE},
+	"serverZone.requestsPerSecond":      {"requests", metric.RATE},
+	"serverZone.responses1xxPerSecond":  {"responses.1xx", metric.RATE},
+	"serverZone.responses2xxPerSecond":  {"responses.2xx", metric.RATE},
+	"serverZone.responses3xxPerSecond":  {"responses.3xx", metric.RATE},
+	"serverZone.responses4xxPerSecond":  {"responses.4xx", metric.RATE},
+	"serverZone.responses5xxPerSecond":  {"responses.5xx", metric.RATE},
+	"serverZone.discardedPerSecond":     {"discarded", metric.RATE},
+	"serverZone.receivedBytesPerSecond": {"received", metric.RATE},
+	"serverZone.sentBytesPerSecond":     {"sent", metric.RATE},
+}
+
+var upstreamDefinition = map[string][]interface{}{
+	"upstream.name":                 {"name", metric.ATTRIBUTE},
+	"upstream.keepaliveConnections": {"keepalive", metric.GAUGE},
+	"upstream.zombies":              {"zombies", metric.GAUGE},
+	"upstream.peers":                {"peers", metric.GAUGE},
+	"upstream.peersUp":              {"peers_up", metric.GAUGE},
+}
+
+var upstreamPeerDefinition = map[string][]interface{}{
+	"upstream.name":                       {"upstream", metric.ATTRIBUTE},
+	"peer.id":                             {"id", metric.ATTRIBUTE},
+	"peer.server":                         {"server", metric.ATTRIBUTE},
+	"peer.state":                          {"state", metric.ATTRIBUTE},
+	"peer.backup":                         {"backup", metric.ATTRIBUTE},
+	"peer.weight":                         {"weight", metric.GAUGE},
+	"peer.activeConnections":              {"active", metric.GAUGE},
+	"peer.requestsPerSecond":              {"requests", metric.RATE},
+	"peer.responses1xxPerSecond":          {"responses.1xx", metric.RATE},
+	"peer.responses2xxPerSecond":          {"responses.2xx", metric.RATE},
+	"peer.responses3xxPerSecond":          {"responses.3xx", metric.RATE},
+	"peer.responses4xxPerSecond":          {"responses.4xx", metric.RATE},
+	"peer.responses5xxPerSecond":          {"responses.5xx", metric.RATE},
+	"peer.receivedBytesPerSecond":         {"received", metric.RATE},
+	"peer.sentBytesPerSecond":             {"sent", metric.RATE},
+	"peer.failsPerSecond":                 {"fails", metric.RATE},
+	"peer.unavailPerSecond":               {"unavail", metric.RATE},
+	"peer.healthChecksPerSecond":          {"health_checks.checks", metric.RATE},
+	"peer.healthChecksFailsPerSecond":     {"health_checks.fails", metric.RATE},
+	"peer.healthChecksUnhealthyPerSecond": {"health_checks.unhealthy", metric.RATE},
+	"peer.healthChecksLastPassed":         {"health_checks.last_passed", metric.ATTRIBUTE},
+	"peer.downtimeMilliseconds":           {"downtime", metric.GAUGE},
+	"peer.headerTimeMilliseconds":         {"header_time", metric.GAUGE},
+	"peer.responseTimeMilliseconds":       {"response_time", metric.GAUGE},
+}
+
+var cacheDefinition = map[string][]interface{}{
+	"cache.name":                     {"name", metric.ATTRIBUTE},
+	"cache.cold":                     {"cold", metric.ATTRIBUTE},
+	"cache.sizeBytes":                {"size", metric.GAUGE},
+	"cache.maxSizeBytes":             {"max_size", metric.GAUGE},
+	"cache.hitResponsesPerSecond":    {"hit.responses", metric.RATE},
+	"cache.hitBytesPerSecond":        {"hit.bytes", metric.RATE},
+	"cache.missResponsesPerSecond":   {"miss.responses", metric.RATE},
+	"cache.missBytesPerSecond":       {"miss.bytes", metric.RATE},
+	"cache.bypassResponsesPerSecond": {"bypass.responses", metric.RATE},
+	"cache.bypassBytesPerSecond":     {"bypass.bytes", metric.RATE},
+	"cache.staleBytesPerSecond":      {"stale.bytes", metric.RATE},
+	"cache.expiredBytesPerSecond":    {"expired.bytes", metric.RATE},
+}
+
+// plusEntity holds the metrics of a single server zone, upstream, upstream
+// peer or cache of the NGINX Plus status, which are reported in their own
+// MetricSet.
+type plusEntity struct {
+	eventType  string
+	namespace  string
+	definition map[string][]interface{}
+	metrics    map[string]interface{}
+}
+
+// getPlusEntities walks the server_zones, upstreams and caches roots of the
+// NGINX Plus status document.
+func getPlusEntities(jsonMetrics map[string]interface{}) []plusEntity {
+	entities := make([]plusEntity, 0)
+
+	zones, _ := jsonMetrics["server_zones"].(map[string]interface{})
+	for _, name := range sortedKeys(zones) {
+		zone, ok := zones[name].(map[string]interface{})
+		if !ok {
+			log.Warn("Can't assert type for server zone %s", name)
+			continue
+		}
+		metrics := flattenJSON(zone)
+		metrics["name"] = name
+		entities = append(entities, plusEntity{"NginxServerZoneSample", "serverZone/" + name, serverZoneDefinition, metrics})
+	}
+
+	upstreams, _ := jsonMetrics["upstreams"].(map[string]interface{})
+	for _, name := range sortedKeys(upstreams) {
+		var upstream map[string]interface{}
+		var peers []interface{}
+
+		// Older status versions list the peers of an upstream directly
+		switch node := upstreams[name].(type) {
+		case map[string]interface{}:
+			upstream = node
+			peers, _ = node["peers"].([]interface{})
+		case []interface{}:
+			upstream = map[string]interface{}{}
+			peers = node
+		default:
+			log.Warn("Can't assert type for upstream %s", name)
+			continue
+		}
+
+		peersUp := 0
+		for _, rawPeer := range peers {
+			peer, ok := rawPeer.(map[string]interface{})
+			if !ok {
+				log.Warn("Can't assert type for peer of upstream %s", name)
+				continue
+			}
+			metrics := flattenJSON(peer)
+			metrics["upstream"] = name
+			if id, ok := metrics["id"]; ok {
+				metrics["id"] = fmt.Sprintf("%v", id)
+			}
+			if metrics["state"] == "up" {
+				peersUp++
+			}
+			namespace := fmt.Sprintf("upstream/%s/%v/%v", name, metrics["id"], metrics["server"])
+			entities = append(entities, plusEntity{"NginxUpstreamPeerSample", namespace, upstreamPeerDefinition, metrics})
+		}
+
+		metrics := flattenJSON(upstream)
+		metrics["name"] = name
+		metrics["peers"] = len(peers)
+		metrics["peers_up"] = peersUp
+		entities = append(entities, plusEntity{"NginxUpstreamSample", "upstream/" + name, upstreamDefinition, metrics})
+	}
+
+	caches, _ := jsonMetrics["caches"].(map[string]interface{})
+	for _, name := range sortedKeys(caches) {
+		cacheNode, ok := caches[name].(map[string]interface{})
+		if !ok {
+			log.Warn("Can't assert type for cache %s", name)
+			continue
+		}
+		metrics := flattenJSON(cacheNode)
+		metrics["name"] = name
+		entities = append(entities, plusEntity{"NginxCacheSample", "cache/" + name, cacheDefinition, metrics})
+	}
+
+	return entities
+}
+
+// flattenJSON turns nested JSON objects into a single level map with dotted
+// keys (e.g. "responses.2xx"). Integral numbers are converted to int and
+// booleans and other scalars to strings, so they can be used as attributes.
+func flattenJSON(node map[string]interface{}) map[string]interface{} {
+	metrics := make(map[string]interface{})
+	flattenJSONInto("", node, metrics)
+	return metrics
+}
+
+func flattenJSONInto(prefix string, node map[string]interface{}, metrics map[string]interface{}) {
+	for key, value := range node {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenJSONInto(key, v, metrics)
+		case float64:
+			if v == float64(int(v)) {
+				metrics[key] = int(v)
+			} else {
+				metrics[key] = v
+			}
+		case bool:
+			metrics[key] = strconv.FormatBool(v)
+		case string:
+			metrics[key] = v
+		}
+	}
+}
+
+func sortedKeys(node map[string]interface{}) []string {
+	keys := make([]string, 0, len(node))
+	for key := range node {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func populatePlusEntities(integration *sdk.Integration, entities []plusEntity) {
+	for _, entity := range entities {
+		ms := integration.NewMetricSet(entity.eventType)
+		populateEntityMetrics(ms, entity.metrics, entity.definition, entity.namespace)
+	}
+}
+
+// populateEntityMetrics works like populateMetrics, but samples the RATE and
+// DELTA metrics under the namespace of the entity, so every zone, upstream
+// peer or cache keeps its own counters.
+func populateEntityMetrics(sample *metric.MetricSet, metrics map[string]interface{}, definition map[string][]interface{}, namespace string) {
+	sampled := make(map[string]interface{})
+	sampledDefinition := make(map[string][]interface{})
+
+	for metricName, metricInfo := range definition {
+		metricType := metricInfo[1].(metric.SourceType)
+		source, isKey := metricInfo[0].(string)
+		if !isKey || (metricType != metric.RATE && metricType != metric.DELTA) {
+			sampledDefinition[metricName] = metricInfo
+			continue
+		}
+
+		rawMetric, ok := metrics[source]
+		if !ok {
+			continue
+		}
+		value, err := sampler.Sample(sampler.Key(namespace, metricName), rawMetric, metricType)
+		if err != nil {
+			log.Warn("Error setting value: %s", err)
+			continue
+		}
+		sampled[metricName] = value
+		sampledDefinition[metricName] = []interface{}{metricName, metric.GAUGE}
+	}
+
+	for key, value := range metrics {
+		if _, ok := sampled[key]; !ok {
+			sampled[key] = value
+		}
+	}
+	populateMetrics(sample, sampled, sampledDefinition)
+}
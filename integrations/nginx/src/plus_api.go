@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// plusAPIEndpoints maps the roots of the legacy NGINX Plus status document to
+// the REST API endpoints that provide them.
+var plusAPIEndpoints = map[string]string{
+	"connections":  "connections",
+	"ssl":          "ssl",
+	"requests":     "http/requests",
+	"server_zones": "http/server_zones",
+	"upstreams":    "http/upstreams",
+	"caches":       "http/caches",
+}
+
+// errAPINotFound is returned for endpoints the NGINX Plus build doesn't serve
+var errAPINotFound = fmt.Errorf("Endpoint not found")
+
+// plusAPIRoot returns the /api/ URL on the same host as the status URL
+func plusAPIRoot(statusURL string) (string, error) {
+	u, err := url.Parse(statusURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("Invalid status URL '%s'", statusURL)
+	}
+	return fmt.Sprintf("%s://%s/api/", u.Scheme, u.Host), nil
+}
+
+// getPlusAPIVersion returns the highest API version listed by /api/
+func getPlusAPIVersion(client *http.Client, apiRoot string) (int, error) {
+	var versions []int
+	if err := getJSON(client, apiRoot, &versions); err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, fmt.Errorf("No NGINX Plus API versions listed at %s", apiRoot)
+	}
+
+	version := versions[0]
+	for _, v := range versions[1:] {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// getPlusAPIStatus rebuilds the legacy NGINX Plus status document from the
+// versioned REST API, so it can be processed as the /status one.
+func getPlusAPIStatus(client *http.Client, statusURL string) (map[string]interface{}, error) {
+	apiRoot, err := plusAPIRoot(statusURL)
+	if err != nil {
+		return nil, err
+	}
+	version, err := getPlusAPIVersion(client, apiRoot)
+	if err != nil {
+		return nil, err
+	}
+	versionRoot := fmt.Sprintf("%s%d/", apiRoot, version)
+
+	var nginx map[string]interface{}
+	if err = getJSON(client, versionRoot+"nginx", &nginx); err != nil {
+		return nil, err
+	}
+	jsonMetrics := map[string]interface{}{
+		"nginx_version": nginx["version"],
+	}
+
+	for root, endpoint := range plusAPIEndpoints {
+		var node interface{}
+		err := getJSON(client, versionRoot+endpoint, &node)
+		if err == errAPINotFound {
+			log.Debug("NGINX Plus API endpoint %s not available", endpoint)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jsonMetrics[root] = node
+	}
+	return jsonMetrics, nil
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errAPINotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %d from %s", resp.StatusCode, url)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Invalid JSON from %s: %s", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+var testNginxPlusAPI = map[string]string{
+	"/api/":                    `[1, 3, 2]`,
+	"/api/3/nginx":             `{"version": "1.13.4", "build": "nginx-plus-r13", "generation": 1, "pid": 32212}`,
+	"/api/3/connections":       `{"accepted": 4968119, "dropped": 0, "active": 5, "idle": 117}`,
+	"/api/3/ssl":               `{"handshakes": 79572, "handshakes_failed": 21025, "session_reuses": 15762}`,
+	"/api/3/http/requests":     `{"total": 10624511, "current": 4}`,
+	"/api/3/http/server_zones": `{"hg.nginx.org": {"processing": 0, "requests": 175276, "responses": {"2xx": 162948}, "discarded": 8, "received": 44326862, "sent": 3767406090}}`,
+	"/api/3/http/upstreams": `{"trac-backend": {"peers": [{"id": 0, "server": "10.0.0.1:8080", "backup": false, "weight": 1, "state": "up", "active": 0, "requests": 103207}],
+		"keepalive": 0, "zombies": 0, "zone": "trac-backend"}}`,
+}
+
+// testNginxPlusAPIv8 has the nested ssl object of API version 8 and later
+var testNginxPlusAPIv8 = map[string]string{
+	"/api/":              `[1, 2, 3, 4, 5, 6, 7, 8]`,
+	"/api/8/nginx":       `{"version": "1.21.6", "build": "nginx-plus-r27", "generation": 1, "pid": 32212}`,
+	"/api/8/connections": `{"accepted": 4968119, "dropped": 0, "active": 5, "idle": 117}`,
+	"/api/8/ssl": `{"handshakes": 79572, "handshakes_failed": 21025, "session_reuses": 15762, "no_common_protocol": 4, "no_common_cipher": 2,
+		"handshake_timeout": 0, "peer_rejected_cert": 0, "verify_failures": {"no_cert": 0, "expired_cert": 2, "revoked_cert": 1, "hostname_mismatch": 2, "other": 1}}`,
+	"/api/8/http/requests": `{"total": 10624511, "current": 4}`,
+}
+
+func testNginxServer(routes map[string]string, contentType string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestGetPlusAPIStatus(t *testing.T) {
+	server := testNginxServer(testNginxPlusAPI, "application/json")
+	defer server.Close()
+
+	client := &http.Client{Timeout: time.Second}
+	jsonMetrics, err := getPlusAPIStatus(client, server.URL+"/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := jsonMetrics["caches"]; ok {
+		t.Error()
+	}
+
+	rawMetrics, entities := plusMetricsFromJSON(jsonMetrics)
+	if rawMetrics["version"] != "1.13.4" {
+		t.Error()
+	}
+	if rawMetrics["connections.accepted"] != 4968119 {
+		t.Error()
+	}
+	if rawMetrics["requests.total"] != 10624511 {
+		t.Error()
+	}
+	if rawMetrics["ssl.handshakes"] != 79572 {
+		t.Error()
+	}
+	if len(entities) != 3 {
+		t.Fatal()
+	}
+	if entities[0].eventType != "NginxServerZoneSample" || entities[2].metrics["peers"] != 1 {
+		t.Error()
+	}
+}
+
+func TestGetPlusAPIStatusWithNestedSSL(t *testing.T) {
+	server := testNginxServer(testNginxPlusAPIv8, "application/json")
+	defer server.Close()
+
+	client := &http.Client{Timeout: time.Second}
+	jsonMetrics, err := getPlusAPIStatus(client, server.URL+"/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawMetrics, _ := plusMetricsFromJSON(jsonMetrics)
+	if rawMetrics["version"] != "1.21.6" || rawMetrics["ssl.handshakes_failed"] != 21025 {
+		t.Error()
+	}
+	if rawMetrics["ssl.verify_failures.expired_cert"] != 2 || rawMetrics["ssl.verify_failures.hostname_mismatch"] != 2 {
+		t.Error()
+	}
+
+	args = argumentList{StatusURL: server.URL + "/status", PlusAPIDiscovery: true}
+	defer func() { args = argumentList{} }()
+
+	integration := &sdk.Integration{}
+	sample := integration.NewMetricSet("NginxSample")
+	if err = getMetricsData(integration, sample); err != nil {
+		t.Fatal(err)
+	}
+	if (*sample)["software.version"] != "1.21.6" {
+		t.Error()
+	}
+}
+
+func TestGetPlusAPIStatusWithoutAPI(t *testing.T) {
+	server := testNginxServer(map[string]string{"/status": testNginxPlusStatus}, "application/json")
+	defer server.Close()
+
+	client := &http.Client{Timeout: time.Second}
+	if _, err := getPlusAPIStatus(client, server.URL+"/status"); err == nil {
+		t.Error()
+	}
+}
+
+func TestGetMetricsDataFallsBackToLegacyStatus(t *testing.T) {
+	server := testNginxServer(map[string]string{"/status": testNginxPlusStatus}, "application/json")
+	defer server.Close()
+
+	args = argumentList{StatusURL: server.URL + "/status", PlusAPIDiscovery: true}
+	defer func() { args = argumentList{} }()
+
+	integration := &sdk.Integration{}
+	sample := integration.NewMetricSet("NginxSample")
+	if err := getMetricsData(integration, sample); err != nil {
+		t.Fatal(err)
+	}
+	if (*sample)["software.edition"] != "plus" {
+		t.Error()
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+var testNginxPlusFullStatus = `{
+  "version": 8,
+  "nginx_version": "1.11.10",
+  "timestamp": 1490347905131,
+  "connections": {"accepted": 4112716, "dropped": 0, "active": 6, "idle": 41},
+  "ssl": {"handshakes": 79572, "handshakes_failed": 21025, "session_reuses": 15762},
+  "requests": {"total": 9353067, "current": 5},
+  "server_zones": {
+    "hg.nginx.org": {
+      "processing": 1,
+      "requests": 175276,
+      "responses": {"1xx": 0, "2xx": 162948, "3xx": 10489, "4xx": 1799, "5xx": 32, "total": 175268},
+      "discarded": 8,
+      "received": 44326862,
+      "sent": 3767406090
+    }
+  },
+  "upstreams": {
+    "trac-backend": {
+      "peers": [
+        {"id": 0, "server": "10.0.0.1:8080", "backup": false, "weight": 1, "state": "up", "active": 2,
+         "requests": 103207, "responses": {"1xx": 0, "2xx": 101830, "3xx": 1106, "4xx": 236, "5xx": 35, "total": 103207},
+         "sent": 44324545, "received": 3737040562, "fails": 0, "unavail": 0,
+         "health_checks": {"checks": 26214, "fails": 0, "unhealthy": 0, "last_passed": true},
+         "downtime": 0, "downstart": 0, "selected": 1490347905000, "header_time": 20, "response_time": 36},
+        {"id": 1, "server": "10.0.0.2:8080", "backup": true, "weight": 1, "state": "unhealthy", "active": 0,
+         "requests": 0, "responses": {"1xx": 0, "2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0, "total": 0},
+         "sent": 0, "received": 0, "fails": 12, "unavail": 3,
+         "health_checks": {"checks": 26284, "fails": 26284, "unhealthy": 1, "last_passed": false},
+         "downtime": 262925617, "downstart": 1490085000000}
+      ],
+      "keepalive": 2,
+      "zombies": 0
+    },
+    "legacy-backend": [
+      {"id": 0, "server": "10.0.0.3:80", "backup": false, "weight": 1, "state": "down", "active": 0, "requests": 5}
+    ]
+  },
+  "caches": {
+    "http_cache": {
+      "size": 530915328, "max_size": 536870912, "cold": false,
+      "hit": {"responses": 254032, "bytes": 6685627875},
+      "stale": {"responses": 0, "bytes": 0},
+      "updating": {"responses": 0, "bytes": 0},
+      "revalidated": {"responses": 0, "bytes": 0},
+      "miss": {"responses": 1619201, "bytes": 53841943822},
+      "expired": {"responses": 45859, "bytes": 1656847080},
+      "bypass": {"responses": 109, "bytes": 5040},
+      "hit_rate": 0.12
+    }
+  }
+}
+`
+
+func TestGetPlusStatus(t *testing.T) {
+	rawMetrics, entities, err := getPlusStatus(bufio.NewReader(strings.NewReader(testNginxPlusFullStatus)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawMetrics["ssl.handshakes_failed"] != 21025 {
+		t.Error()
+	}
+
+	byEventType := make(map[string][]plusEntity)
+	for _, entity := range entities {
+		byEventType[entity.eventType] = append(byEventType[entity.eventType], entity)
+	}
+
+	zones := byEventType["NginxServerZoneSample"]
+	if len(zones) != 1 || zones[0].metrics["name"] != "hg.nginx.org" || zones[0].metrics["responses.5xx"] != 32 {
+		t.Errorf("unexpected server zones %v", zones)
+	}
+
+	peers := byEventType["NginxUpstreamPeerSample"]
+	if len(peers) != 3 {
+		t.Fatalf("expected 3 peers, got %d", len(peers))
+	}
+	// Upstreams are walked in alphabetical order
+	if peers[0].metrics["upstream"] != "legacy-backend" || peers[0].metrics["server"] != "10.0.0.3:80" {
+		t.Errorf("unexpected peer %v", peers[0].metrics)
+	}
+	down := peers[2].metrics
+	if down["upstream"] != "trac-backend" || down["state"] != "unhealthy" || down["backup"] != "true" {
+		t.Errorf("unexpected peer %v", down)
+	}
+	if down["health_checks.last_passed"] != "false" || down["fails"] != 12 {
+		t.Errorf("unexpected peer %v", down)
+	}
+	if down["id"] != "1" {
+		t.Errorf("unexpected peer %v", down)
+	}
+	if peers[1].namespace == peers[2].namespace {
+		t.Error("peers must be sampled apart")
+	}
+
+	upstreams := byEventType["NginxUpstreamSample"]
+	if len(upstreams) != 2 {
+		t.Fatalf("expected 2 upstreams, got %d", len(upstreams))
+	}
+	trac := upstreams[1].metrics
+	if trac["name"] != "trac-backend" || trac["peers"] != 2 || trac["peers_up"] != 1 || trac["keepalive"] != 2 {
+		t.Errorf("unexpected upstream %v", trac)
+	}
+
+	caches := byEventType["NginxCacheSample"]
+	if len(caches) != 1 || caches[0].metrics["miss.bytes"] != 53841943822 || caches[0].metrics["cold"] != "false" {
+		t.Errorf("unexpected caches %v", caches)
+	}
+}
+
+func TestPlusMetricsFromJSONWithNestedObjects(t *testing.T) {
+	jsonMetrics := map[string]interface{}{
+		"ssl": map[string]interface{}{
+			"handshakes":      float64(79572),
+			"verify_failures": map[string]interface{}{"expired_cert": float64(2)},
+		},
+		"connections": map[string]interface{}{"accepted": float64(4968119), "reason": "unknown"},
+	}
+	rawMetrics, _ := plusMetricsFromJSON(jsonMetrics)
+	if rawMetrics["ssl.handshakes"] != 79572 || rawMetrics["ssl.verify_failures.expired_cert"] != 2 {
+		t.Error()
+	}
+	if rawMetrics["connections.accepted"] != 4968119 {
+		t.Error()
+	}
+}
+
+func TestPopulatePlusEntities(t *testing.T) {
+	_, entities, err := getPlusStatus(bufio.NewReader(strings.NewReader(testNginxPlusFullStatus)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	integration := &sdk.Integration{}
+	populatePlusEntities(integration, entities)
+
+	if len(integration.Metrics) != len(entities) {
+		t.Fatal()
+	}
+	for _, ms := range integration.Metrics {
+		sample := *ms
+		if sample["event_type"] != "NginxUpstreamPeerSample" || sample["peer.server"] != "10.0.0.2:8080" {
+			continue
+		}
+		if sample["peer.state"] != "unhealthy" || sample["upstream.name"] != "trac-backend" {
+			t.Errorf("unexpected sample %v", sample)
+		}
+		if sample["peer.downtimeMilliseconds"] != 262925617 {
+			t.Errorf("unexpected sample %v", sample)
+		}
+		// First sample of a rate is always 0
+		if sample["peer.failsPerSecond"] != float64(0) {
+			t.Errorf("unexpected sample %v", sample)
+		}
+		return
+	}
+	t.Error("peer sample not found")
+}
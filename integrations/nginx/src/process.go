@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/cache"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+	"github.com/newrelic/infra-integrations/pkg/sampler"
+)
+
+// defaultPidFile is used when the configuration has no pid directive
+const defaultPidFile = "/run/nginx.pid"
+
+// clockTicks is the USER_HZ the CPU times of /proc/<pid>/stat are given in,
+// which is 100 on every Linux architecture NGINX runs on.
+const clockTicks = 100
+
+var workerDefinition = map[string][]interface{}{
+	"worker.pid":                 {"pid", metric.ATTRIBUTE},
+	"worker.rssBytes":            {"rss", metric.GAUGE},
+	"worker.cpuPercent":          {"cpu", metric.GAUGE},
+	"worker.openFileDescriptors": {"fds", metric.GAUGE},
+	"worker.maxFileDescriptors":  {"max_fds", metric.GAUGE},
+}
+
+var metricsProcessDefinition = map[string][]interface{}{
+	"process.workers":             {"workers", metric.GAUGE},
+	"process.workersConfigured":   {"workers_configured", metric.GAUGE},
+	"process.rssBytes":            {"rss", metric.GAUGE},
+	"process.cpuPercent":          {"cpu", metric.GAUGE},
+	"process.openFileDescriptors": {"fds", metric.GAUGE},
+	"process.maxFileDescriptors":  {"max_fds", metric.GAUGE},
+}
+
+// procStat holds the fields of a process read from /proc
+type procStat struct {
+	pid     int
+	ppid    int
+	cmdline string
+	cpuTime float64
+	rss     int
+	fds     int
+	maxFds  int
+}
+
+// readStat reads the parent and CPU time of a process from the stat file of
+// a /proc tree
+func readStat(procPath string, pid int) (*procStat, error) {
+	stat, err := ioutil.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name between parentheses may contain spaces
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return nil, fmt.Errorf("Invalid stat of process %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 13 {
+		return nil, fmt.Errorf("Invalid stat of process %d", pid)
+	}
+	process := &procStat{pid: pid}
+	process.ppid, _ = strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	process.cpuTime = (utime + stime) / clockTicks
+	return process, nil
+}
+
+// readCmdline sets the command line of a process
+func readCmdline(procPath string, process *procStat) {
+	if cmdline, err := ioutil.ReadFile(filepath.Join(procPath, strconv.Itoa(process.pid), "cmdline")); err == nil {
+		process.cmdline = strings.TrimSpace(string(bytes.Replace(cmdline, []byte{0}, []byte{' '}, -1)))
+	}
+}
+
+// readProcDetails sets the memory and file descriptors of a process
+func readProcDetails(procPath string, process *procStat) {
+	dir := filepath.Join(procPath, strconv.Itoa(process.pid))
+	process.rss = readProcValue(filepath.Join(dir, "status"), "VmRSS:") * 1024
+	process.maxFds = readProcValue(filepath.Join(dir, "limits"), "Max open files")
+	if fds, err := ioutil.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		process.fds = len(fds)
+	} else {
+		log.Debug("Can't count file descriptors of process %d: %s", process.pid, err)
+	}
+}
+
+// readProcValue returns the first number following prefix in a /proc file
+// like status or limits.
+func readProcValue(path string, prefix string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, prefix))
+		if len(fields) > 0 {
+			value, _ := strconv.Atoi(fields[0])
+			return value
+		}
+	}
+	return 0
+}
+
+// getWorkers returns the worker processes of the master sorted by PID,
+// leaving out the cache manager and loader. Only the stat file of the other
+// processes is read.
+func getWorkers(procPath string, masterPid int) ([]*procStat, error) {
+	dir, err := os.Open(procPath)
+	if err != nil {
+		return nil, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make([]int, 0, len(names))
+	for _, name := range names {
+		if pid, err := strconv.Atoi(name); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	workers := make([]*procStat, 0)
+	for _, pid := range pids {
+		process, err := readStat(procPath, pid)
+		if err != nil || process.ppid != masterPid {
+			// the process may have exited meanwhile
+			continue
+		}
+		readCmdline(procPath, process)
+		if strings.Contains(process.cmdline, "worker process") {
+			readProcDetails(procPath, process)
+			workers = append(workers, process)
+		}
+	}
+	return workers, nil
+}
+
+// sampleWorkerCPU returns the CPU usage of the worker in the given slot,
+// from 0 to 1 per core. The CPU time is cached per slot rather than per PID,
+// since the cache can't forget the workers that exited; a worker taking the
+// slot of another one has no usage until the next run.
+func sampleWorkerCPU(slot int, worker *procStat) float64 {
+	pidKey := fmt.Sprintf("worker/%d/pid", slot)
+	oldPid, _, found := cache.Get(pidKey)
+	cache.Set(pidKey, float64(worker.pid))
+
+	cpu, err := sampler.Sample(fmt.Sprintf("worker/%d/cpu", slot), worker.cpuTime, metric.RATE)
+	if !found || int(oldPid) != worker.pid {
+		return 0
+	}
+	if err != nil {
+		log.Warn("Error setting value: %s", err)
+	}
+	return cpu
+}
+
+// readPidFile returns the PID of the master process
+func readPidFile(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid PID file %s", path)
+	}
+	return pid, nil
+}
+
+// mainDirective returns the value of a directive of the main context
+func mainDirective(directives []*directive, name string) string {
+	if found := findDirectives(directives, name); len(found) > 0 {
+		return unquote(found[0].value())
+	}
+	return ""
+}
+
+// configuredWorkers returns the worker_processes value, "auto" meaning one
+// per CPU.
+func configuredWorkers(directives []*directive) int {
+	value := mainDirective(directives, "worker_processes")
+	switch value {
+	case "":
+		return 1
+	case "auto":
+		return runtime.NumCPU()
+	}
+	workers, _ := strconv.Atoi(value)
+	return workers
+}
+
+// populateProcessMetrics reports a NginxWorkerSample per worker process of
+// the master found in the pid directive, and their totals in sample.
+func populateProcessMetrics(integration *sdk.Integration, sample *metric.MetricSet, directives []*directive, dir string) error {
+	pidFile := mainDirective(directives, "pid")
+	if pidFile == "" {
+		pidFile = defaultPidFile
+	} else if !filepath.IsAbs(pidFile) {
+		pidFile = filepath.Join(dir, pidFile)
+	}
+	masterPid, err := readPidFile(pidFile)
+	if err != nil {
+		return err
+	}
+	workers, err := getWorkers(args.ProcPath, masterPid)
+	if err != nil {
+		return err
+	}
+
+	rlimit, _ := strconv.Atoi(mainDirective(directives, "worker_rlimit_nofile"))
+	totalRss, totalFds, totalCPU := 0, 0, 0.0
+	for slot, worker := range workers {
+		cpu := sampleWorkerCPU(slot, worker)
+		if rlimit > 0 {
+			worker.maxFds = rlimit
+		}
+
+		metrics := map[string]interface{}{
+			"pid":     strconv.Itoa(worker.pid),
+			"rss":     worker.rss,
+			"cpu":     cpu * 100,
+			"fds":     worker.fds,
+			"max_fds": worker.maxFds,
+		}
+		ms := integration.NewMetricSet("NginxWorkerSample")
+		populateMetrics(ms, metrics, workerDefinition)
+
+		totalRss += worker.rss
+		totalFds += worker.fds
+		totalCPU += cpu * 100
+	}
+
+	totals := map[string]interface{}{
+		"workers":            len(workers),
+		"workers_configured": configuredWorkers(directives),
+		"rss":                totalRss,
+		"cpu":                totalCPU,
+		"fds":                totalFds,
+	}
+	// Every worker has the same limit
+	if rlimit > 0 {
+		totals["max_fds"] = rlimit
+	} else if len(workers) > 0 {
+		totals["max_fds"] = workers[0].maxFds
+	}
+	return populateMetrics(sample, totals, metricsProcessDefinition)
+}
+
+func setProcessMetrics(integration *sdk.Integration, sample *metric.MetricSet) error {
+	directives, err := readConfig()
+	if err != nil {
+		return err
+	}
+	return populateProcessMetrics(integration, sample, directives, filepath.Dir(args.ConfigPath))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/cache"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+type fakeProcess struct {
+	pid     int
+	ppid    int
+	cmdline string
+	utime   int
+	stime   int
+	rssKB   int
+	fds     int
+}
+
+func writeFakeProcess(t *testing.T, procPath string, p fakeProcess) {
+	dir := filepath.Join(procPath, fmt.Sprint(p.pid))
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"stat":    fmt.Sprintf("%d (nginx) S %d %d %d 0 -1 4194624 100 0 0 0 %d %d 0 0 20 0 1 0 1000", p.pid, p.ppid, p.ppid, p.ppid, p.utime, p.stime),
+		"cmdline": strings.Replace(p.cmdline, " ", "\x00", -1) + "\x00",
+		"status":  fmt.Sprintf("Name:\tnginx\nPPid:\t%d\nVmRSS:\t    %d kB\n", p.ppid, p.rssKB),
+		"limits":  "Limit                     Soft Limit           Hard Limit           Units\nMax open files            1024                 4096                 files\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for fd := 0; fd < p.fds; fd++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, "fd", fmt.Sprint(fd)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFakeProcess(t, dir, fakeProcess{101, 100, "nginx: worker process", 150, 50, 2048, 3})
+	process, err := readStat(dir, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if process.ppid != 100 || process.cpuTime != 2 {
+		t.Error()
+	}
+	// Only the stat file is read until the process is known to be a worker
+	if process.cmdline != "" || process.rss != 0 || process.fds != 0 {
+		t.Error()
+	}
+
+	readCmdline(dir, process)
+	if process.cmdline != "nginx: worker process" {
+		t.Error()
+	}
+	readProcDetails(dir, process)
+	if process.rss != 2048*1024 || process.fds != 3 || process.maxFds != 1024 {
+		t.Error()
+	}
+
+	if _, err = readStat(dir, 102); err == nil {
+		t.Error()
+	}
+}
+
+func TestConfiguredWorkers(t *testing.T) {
+	configs := map[string]int{
+		"":                       1,
+		"worker_processes 4;":    4,
+		"worker_processes auto;": runtime.NumCPU(),
+	}
+	for config, workers := range configs {
+		directives, err := parseConfig(bufio.NewReader(strings.NewReader(config)), "nginx.conf", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if configuredWorkers(directives) != workers {
+			t.Error()
+		}
+	}
+}
+
+func TestPopulateProcessMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	procPath := filepath.Join(dir, "proc")
+	processes := []fakeProcess{
+		{100, 1, "nginx: master process /usr/sbin/nginx", 10, 10, 1024, 8},
+		{101, 100, "nginx: worker process", 150, 50, 2048, 3},
+		{102, 100, "nginx: worker process", 100, 0, 4096, 5},
+		{103, 100, "nginx: cache manager process", 10, 0, 1024, 2},
+		{200, 1, "nginx: worker process", 10, 0, 1024, 2},
+	}
+	for _, p := range processes {
+		writeFakeProcess(t, procPath, p)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "nginx.pid"), []byte("100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := "pid nginx.pid;\nworker_processes 3;\nworker_rlimit_nofile 8192;\n"
+	directives, err := parseConfig(bufio.NewReader(strings.NewReader(config)), "nginx.conf", dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args = argumentList{ProcPath: procPath}
+	defer func() { args = argumentList{} }()
+
+	now := time.Now()
+	cache.SetNow(func() time.Time { return now })
+	defer cache.SetNow(time.Now)
+
+	integration := &sdk.Integration{}
+	sample := integration.NewMetricSet("NginxSample")
+	if err = populateProcessMetrics(integration, sample, directives, dir); err != nil {
+		t.Fatal(err)
+	}
+	if (*sample)["process.workers"] != 2 || (*sample)["process.workersConfigured"] != 3 {
+		t.Error()
+	}
+	if (*sample)["process.rssBytes"] != 6144*1024 || (*sample)["process.openFileDescriptors"] != 8 {
+		t.Error()
+	}
+	if (*sample)["process.maxFileDescriptors"] != 8192 {
+		t.Error()
+	}
+
+	// Worker 101 spends 2 more seconds of CPU in 10 seconds
+	writeFakeProcess(t, procPath, fakeProcess{101, 100, "nginx: worker process", 250, 150, 2048, 3})
+	now = now.Add(10 * time.Second)
+	integration = &sdk.Integration{}
+	sample = integration.NewMetricSet("NginxSample")
+	if err = populateProcessMetrics(integration, sample, directives, dir); err != nil {
+		t.Fatal(err)
+	}
+	if (*sample)["process.cpuPercent"] != float64(20) {
+		t.Error()
+	}
+
+	workers := 0
+	for _, ms := range integration.Metrics {
+		if (*ms)["event_type"] != "NginxWorkerSample" {
+			continue
+		}
+		workers++
+		if (*ms)["worker.pid"] == "101" && (*ms)["worker.cpuPercent"] != float64(20) {
+			t.Error()
+		}
+		if (*ms)["worker.maxFileDescriptors"] != 8192 {
+			t.Error()
+		}
+	}
+	if workers != 2 {
+		t.Error()
+	}
+
+	// Worker 101 exits and 104 takes its slot, without inheriting its CPU time
+	if err = os.RemoveAll(filepath.Join(procPath, "101")); err != nil {
+		t.Fatal(err)
+	}
+	writeFakeProcess(t, procPath, fakeProcess{104, 100, "nginx: worker process", 500, 500, 2048, 3})
+	now = now.Add(10 * time.Second)
+	integration = &sdk.Integration{}
+	sample = integration.NewMetricSet("NginxSample")
+	if err = populateProcessMetrics(integration, sample, directives, dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, ms := range integration.Metrics {
+		if (*ms)["event_type"] == "NginxWorkerSample" && (*ms)["worker.cpuPercent"] != float64(0) {
+			t.Errorf("unexpected sample %v", *ms)
+		}
+	}
+
+	// The master isn't running
+	if err = ioutil.WriteFile(filepath.Join(dir, "nginx.pid"), []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = populateProcessMetrics(integration, sample, directives, dir); err == nil {
+		t.Error()
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+// defaultListenPort is used by server blocks without a listen directive
+const defaultListenPort = "80"
+
+// vhost is a server block, identified by its first server_name, a port and
+// the address it listens on, empty for every address
+type vhost struct {
+	name        string
+	address     string
+	port        string
+	serverNames []string
+	listen      []string
+	server      *directive
+}
+
+// label returns the server_name and port of a vhost, e.g. "www.example.com:443",
+// with the address in between when it listens on a single one.
+func (v *vhost) label() string {
+	if v.address != "" {
+		return fmt.Sprintf("%s:%s:%s", v.name, v.address, v.port)
+	}
+	return fmt.Sprintf("%s:%s", v.name, v.port)
+}
+
+func (v *vhost) key() string {
+	return "vhost/" + keySegment(v.label())
+}
+
+// findDirectives returns the directives named name in a block
+func findDirectives(block []*directive, name string) []*directive {
+	found := make([]*directive, 0)
+	for _, d := range block {
+		if d.name == name {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// listenAddress returns the address and port of a listen directive, which
+// may be given as "port", "address", "address:port", "[ipv6]:port" or a unix
+// socket. The address is empty when listening on every address.
+func listenAddress(listen string) (string, string) {
+	listen = unquote(listen)
+	if strings.HasPrefix(listen, "unix:") {
+		return "", listen
+	}
+
+	address, port := listen, defaultListenPort
+	if idx := strings.LastIndex(listen, ":"); idx >= 0 && idx > strings.LastIndex(listen, "]") {
+		address, port = listen[:idx], listen[idx+1:]
+	} else if strings.Trim(listen, "0123456789") == "" {
+		address, port = "", listen
+	}
+	if address == "*" || address == "0.0.0.0" || address == "[::]" {
+		address = ""
+	}
+	return address, port
+}
+
+// getVhosts returns a vhost per address and port every server block listens
+// on. A server block conflicting with a previous one on the same address and
+// port is ignored, as NGINX does.
+func getVhosts(directives []*directive) []*vhost {
+	vhosts := make([]*vhost, 0)
+	seen := make(map[string]bool)
+
+	for _, http := range findDirectives(directives, "http") {
+		for _, server := range findDirectives(http.block, "server") {
+			serverNames := make([]string, 0)
+			for _, d := range findDirectives(server.block, "server_name") {
+				for _, name := range d.args {
+					serverNames = append(serverNames, unquote(name))
+				}
+			}
+			name := defaultServerName
+			if len(serverNames) > 0 && serverNames[0] != "" {
+				name = serverNames[0]
+			}
+
+			listens := make([]*vhost, 0)
+			byAddress := make(map[string]*vhost)
+			for _, d := range findDirectives(server.block, "listen") {
+				if len(d.args) == 0 {
+					continue
+				}
+				address, port := listenAddress(d.args[0])
+				v, ok := byAddress[address+" "+port]
+				if !ok {
+					v = &vhost{name: name, address: address, port: port, serverNames: serverNames, server: server}
+					byAddress[address+" "+port] = v
+					listens = append(listens, v)
+				}
+				v.listen = append(v.listen, d.value())
+			}
+			if len(listens) == 0 {
+				listens = append(listens, &vhost{name: name, port: defaultListenPort, serverNames: serverNames, server: server})
+			}
+
+			for _, v := range listens {
+				if seen[v.key()] {
+					log.Warn("Conflicting server name %s, ignoring the server block in Line %d", v.label(), server.line)
+					continue
+				}
+				seen[v.key()] = true
+				vhosts = append(vhosts, v)
+			}
+		}
+	}
+	return vhosts
+}
+
+// locationKey returns the inventory path of a location, which includes its
+// modifier when it isn't a prefix location (e.g. "location/=/50x.html").
+func locationKey(location *directive) (string, string, string) {
+	args := make([]string, len(location.args))
+	for i, arg := range location.args {
+		args[i] = unquote(arg)
+	}
+
+	modifier, path := "", strings.Join(args, " ")
+	if len(args) == 2 {
+		modifier, path = args[0], args[1]
+	}
+	key := "location"
+	if modifier != "" {
+		key += "/" + modifier
+	}
+	if !strings.HasPrefix(path, "/") {
+		key += "/"
+	}
+	return key + path, modifier, path
+}
+
+func populateLocationInventory(prefix string, block []*directive, inventory sdk.Inventory) {
+	for _, location := range findDirectives(block, "location") {
+		key, modifier, path := locationKey(location)
+		key = prefix + "/" + key
+
+		inventory.SetItem(key, "path", path)
+		if modifier != "" {
+			inventory.SetItem(key, "modifier", modifier)
+		}
+		for _, name := range []string{"proxy_pass", "fastcgi_pass", "uwsgi_pass", "grpc_pass", "root", "alias", "return"} {
+			if found := findDirectives(location.block, name); len(found) > 0 {
+				inventory.SetItem(key, name, found[0].value())
+			}
+		}
+		// nested locations are listed under the vhost too
+		populateLocationInventory(prefix, location.block, inventory)
+	}
+}
+
+// populateUpstreamInventory lists the servers of every upstream block, with
+// their weight (1 unless set) and the rest of their parameters.
+func populateUpstreamInventory(directives []*directive, inventory sdk.Inventory) {
+	for _, http := range findDirectives(directives, "http") {
+		for _, upstream := range findDirectives(http.block, "upstream") {
+			if len(upstream.args) == 0 {
+				continue
+			}
+			name := unquote(upstream.args[0])
+			for _, server := range findDirectives(upstream.block, "server") {
+				if len(server.args) == 0 {
+					continue
+				}
+				key := fmt.Sprintf("upstream/%s/server/%s", name, keySegment(unquote(server.args[0])))
+
+				weight := "1"
+				params := make([]string, 0)
+				for _, arg := range server.args[1:] {
+					if strings.HasPrefix(arg, "weight=") {
+						weight = strings.TrimPrefix(arg, "weight=")
+					} else {
+						params = append(params, arg)
+					}
+				}
+				inventory.SetItem(key, "weight", weight)
+				if len(params) > 0 {
+					inventory.SetItem(key, "parameters", strings.Join(params, " "))
+				}
+			}
+		}
+	}
+}
+
+// populateVhostInventory adds the server and upstream blocks of the http
+// context as items keyed by their names instead of their position, e.g.
+// "vhost/www.example.com:443/location/status".
+func populateVhostInventory(directives []*directive, inventory sdk.Inventory) {
+	for _, v := range getVhosts(directives) {
+		inventory.SetItem(v.key(), "server_name", strings.Join(v.serverNames, " "))
+		if len(v.listen) > 0 {
+			inventory.SetItem(v.key(), "listen", strings.Join(v.listen, ", "))
+		} else {
+			inventory.SetItem(v.key(), "listen", defaultListenPort)
+		}
+		populateLocationInventory(v.key(), v.server.block, inventory)
+	}
+	populateUpstreamInventory(directives, inventory)
+}
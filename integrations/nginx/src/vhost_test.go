@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+var testNginxVhostConf = `http {
+  upstream backend {
+    least_conn;
+    server 10.0.0.1:8080 weight=5;
+    server 10.0.0.2:8080 max_fails=3 fail_timeout=30s;
+    server unix:/run/app.sock backup;
+  }
+
+  server {
+    listen 80;
+    listen [::]:80;
+    listen 443 ssl http2;
+    server_name www.example.com example.com;
+
+    location / {
+      proxy_pass http://backend;
+    }
+    location = /50x.html {
+      root /usr/share/nginx/html;
+    }
+    location /status {
+      stub_status on;
+      location /status/json {
+        return 204;
+      }
+    }
+  }
+
+  server {
+    listen 127.0.0.1:8080 default_server;
+    location ~ \.php$ {
+      fastcgi_pass unix:/run/php-fpm/www.sock;
+    }
+  }
+
+  server {
+    server_name www.example.com;
+  }
+
+  server {
+    listen 10.0.0.1:443 ssl;
+    server_name api.example.com;
+  }
+
+  server {
+    listen 10.0.0.2:443 ssl;
+    server_name api.example.com;
+  }
+}`
+
+func TestPopulateVhostInventory(t *testing.T) {
+	directives, err := parseConfig(bufio.NewReader(strings.NewReader(testNginxVhostConf)), "nginx.conf", ".", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory := make(sdk.Inventory)
+	populateVhostInventory(directives, inventory)
+
+	if inventory["vhost/www.example.com:80"]["listen"] != "80, [::]:80" {
+		t.Error()
+	}
+	if inventory["vhost/www.example.com:443"]["server_name"] != "www.example.com example.com" {
+		t.Error()
+	}
+	if inventory["vhost/www.example.com:443/location/"]["proxy_pass"] != "http://backend" {
+		t.Error()
+	}
+	if inventory["vhost/www.example.com:443/location/=/50x.html"]["modifier"] != "=" {
+		t.Error()
+	}
+	if inventory["vhost/www.example.com:443/location/status"]["path"] != "/status" {
+		t.Error()
+	}
+	if inventory["vhost/www.example.com:80/location/status/json"]["return"] != "204" {
+		t.Error()
+	}
+	if inventory["vhost/_:127.0.0.1:8080/location/~/\\.php$"]["fastcgi_pass"] != "unix:/run/php-fpm/www.sock" {
+		t.Error()
+	}
+	// Blocks with the same name on different addresses are both kept
+	if inventory["vhost/api.example.com:10.0.0.1:443"]["listen"] != "10.0.0.1:443 ssl" || inventory["vhost/api.example.com:10.0.0.2:443"] == nil {
+		t.Error()
+	}
+	// The third server conflicts with the first one on port 80
+	if len(inventory) != 17 {
+		t.Errorf("Expected 17 items, got %d", len(inventory))
+	}
+
+	if inventory["upstream/backend/server/10.0.0.1:8080"]["weight"] != "5" {
+		t.Error()
+	}
+	if inventory["upstream/backend/server/10.0.0.2:8080"]["parameters"] != "max_fails=3 fail_timeout=30s" {
+		t.Error()
+	}
+	if inventory["upstream/backend/server/unix::run:app.sock"]["weight"] != "1" {
+		t.Error()
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	addresses := map[string][2]string{
+		"8080":              {"", "8080"},
+		"127.0.0.1:8443":    {"127.0.0.1", "8443"},
+		"*:443":             {"", "443"},
+		"[::]:80":           {"", "80"},
+		"[::1]":             {"[::1]", "80"},
+		"localhost":         {"localhost", "80"},
+		"unix:/run/ng.sock": {"", "unix:/run/ng.sock"},
+	}
+	for listen, expected := range addresses {
+		if address, port := listenAddress(listen); address != expected[0] || port != expected[1] {
+			t.Errorf("For %s, expected %v, got %s and %s", listen, expected, address, port)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/newrelic/infra-integrations/pkg/httpclient"
+)
+
+// requestTimeout returns the timeout of the status and ping requests
+func requestTimeout() time.Duration {
+	if args.Timeout <= 0 {
+		return httpclient.DefaultTimeout
+	}
+	return time.Duration(args.Timeout) * time.Second
+}
+
+// newHTTPClient returns the client used to query status_url, as configured
+// in the arguments.
+func newHTTPClient() (*http.Client, error) {
+	headers, err := httpclient.HeadersFromJSON(args.Headers.Get())
+	if err != nil {
+		return nil, err
+	}
+
+	return httpclient.New(httpclient.Options{
+		CABundle:           args.CABundle,
+		ClientCert:         args.ClientCert,
+		ClientKey:          args.ClientKey,
+		InsecureSkipVerify: args.TLSInsecureSkipVerify,
+		Username:           args.Username,
+		Password:           args.Password,
+		Headers:            headers,
+		UnixSocket:         args.UnixSocket,
+		Timeout:            requestTimeout(),
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+// fpmPool is a pool whose status page is queried over FastCGI
+type fpmPool struct {
+	name         string
+	address      string
+	statusPath   string
+	pingPath     string
+	pingResponse string
+}
+
+// discoverPools returns the pools with a status page found in the
+// configuration files.
+func discoverPools() ([]fpmPool, error) {
+	config := newFpmConfig()
+	if err := readConfigFile(args.ConfigPath, config, 0); err != nil {
+		return nil, err
+	}
+	return poolsFromConfig(config), nil
+}
+
+// poolsFromConfig derives the FastCGI endpoint of every configured pool.
+// Pools without pm.status_path are skipped since they can't be monitored.
+func poolsFromConfig(config *fpmConfig) []fpmPool {
+	pools := make([]fpmPool, 0, len(config.poolNames))
+
+	for _, name := range config.poolNames {
+		values := config.pools[name]
+
+		statusPath := poolValue(values, "pm.status_path", name)
+		if statusPath == "" {
+			log.Debug("Pool %s has no pm.status_path, skipping it", name)
+			continue
+		}
+		listen := poolValue(values, "listen", name)
+		if listen == "" {
+			log.Warn("Pool %s has no listen address, skipping it", name)
+			continue
+		}
+
+		pingResponse := poolValue(values, "ping.response", name)
+		if pingResponse == "" {
+			pingResponse = "pong"
+		}
+		pools = append(pools, fpmPool{
+			name:         name,
+			address:      listenAddress(listen),
+			statusPath:   statusPath,
+			pingPath:     poolValue(values, "ping.path", name),
+			pingResponse: pingResponse,
+		})
+	}
+	return pools
+}
+
+// poolValue returns a pool directive, expanding the $pool variable PHP-FPM
+// allows in pool sections.
+func poolValue(values map[string]string, key string, name string) string {
+	return strings.Replace(values[key], "$pool", name, -1)
+}
+
+// listenAddress turns the `listen` directive of a pool into an address to
+// connect to. A bare port or a wildcard address listens on every interface,
+// so the loopback one is used.
+func listenAddress(listen string) string {
+	if strings.HasPrefix(listen, "/") {
+		return listen
+	}
+	if !strings.Contains(listen, ":") {
+		return net.JoinHostPort("127.0.0.1", listen)
+	}
+
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	switch host {
+	case "", "*", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// populatePoolError reports a pool that could not be queried
+func populatePoolError(integration *sdk.Integration, pool fpmPool, err error) {
+	sample := integration.NewMetricSet("PhpFpmSample")
+	sample.SetMetric("pool.name", pool.name, metric.ATTRIBUTE)
+	sample.SetMetric("pool.error", err.Error(), metric.ATTRIBUTE)
+}
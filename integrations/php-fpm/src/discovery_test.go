@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+var testPhpFpmPoolsConf = `[www]
+listen = 127.0.0.1:9000
+pm.status_path = /status
+ping.path = /ping
+
+[api]
+listen = /run/php-fpm/$pool.sock
+pm.status_path = /$pool-status
+ping.response = ok
+
+[admin]
+listen = 9001
+pm.status_path = /status
+
+[private]
+listen = /run/php-fpm/private.sock
+`
+
+func TestPoolsFromConfig(t *testing.T) {
+	config := newFpmConfig()
+	err := parseConfig(bufio.NewReader(strings.NewReader(testPhpFpmPoolsConf)), ".", config, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pools := poolsFromConfig(config)
+	if len(pools) != 3 {
+		t.Fatalf("expected 3 pools, got %d", len(pools))
+	}
+
+	expected := []fpmPool{
+		{name: "www", address: "127.0.0.1:9000", statusPath: "/status", pingPath: "/ping", pingResponse: "pong"},
+		{name: "api", address: "/run/php-fpm/api.sock", statusPath: "/api-status", pingResponse: "ok"},
+		{name: "admin", address: "127.0.0.1:9001", statusPath: "/status", pingResponse: "pong"},
+	}
+	for i, pool := range expected {
+		if pools[i] != pool {
+			t.Errorf("expected %+v, got %+v", pool, pools[i])
+		}
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	cases := map[string]string{
+		"127.0.0.1:9000":        "127.0.0.1:9000",
+		"9000":                  "127.0.0.1:9000",
+		"*:9000":                "127.0.0.1:9000",
+		"0.0.0.0:9000":          "127.0.0.1:9000",
+		"[::]:9000":             "[::1]:9000",
+		"[::1]:9000":            "[::1]:9000",
+		"/run/php-fpm/www.sock": "/run/php-fpm/www.sock",
+	}
+	for listen, expected := range cases {
+		if actual := listenAddress(listen); actual != expected {
+			t.Errorf("for %s expected %s, got %s", listen, expected, actual)
+		}
+	}
+}
+
+func TestPopulateDiscoveredPools(t *testing.T) {
+	listener := fpmResponder(t, "tcp", "127.0.0.1:0")
+	defer listener.Close()
+
+	pools := []fpmPool{
+		{name: "www", address: listener.Addr().String(), statusPath: "/status", pingPath: "/ping", pingResponse: "pong"},
+		{name: "gone", address: listener.Addr().String(), statusPath: "/missing"},
+	}
+
+	integration := &sdk.Integration{}
+	for _, pool := range pools {
+		rawMetrics, processes, err := getPoolMetricsData(pool)
+		if err != nil {
+			populatePoolError(integration, pool, err)
+			continue
+		}
+		populateSamples(integration, rawMetrics, processes, pool.name)
+	}
+
+	if len(integration.Metrics) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(integration.Metrics))
+	}
+	www := *integration.Metrics[0]
+	if www["pool.name"] != "www" || www["net.pingSucceeded"] != 1 {
+		t.Error()
+	}
+	if _, ok := www["pool.error"]; ok {
+		t.Error()
+	}
+	// Rates of discovered pools are sampled per pool and reported as gauges
+	if www["net.connectionsAcceptedPerSecond"] != float64(0) {
+		t.Error()
+	}
+
+	gone := *integration.Metrics[1]
+	if gone["pool.name"] != "gone" || gone["pool.error"] == nil {
+		t.Error()
+	}
+}
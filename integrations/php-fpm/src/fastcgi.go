@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FastCGI record types and constants, see https://fast-cgi.github.io/spec
+const (
+	fcgiVersion      = 1
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+	fcgiResponder    = 1
+	fcgiRequestID    = 1
+	fcgiMaxContent   = 65535
+)
+
+type fcgiHeader struct {
+	Version       uint8
+	Type          uint8
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+// fcgiResponse is the CGI response returned by the FastCGI application
+type fcgiResponse struct {
+	StatusCode int
+	Header     textproto.MIMEHeader
+	Body       []byte
+}
+
+// fastcgiDial connects to a FastCGI listener, which may be a unix socket path
+// (optionally prefixed by "unix:") or a TCP host:port.
+func fastcgiDial(address string, timeout time.Duration) (net.Conn, error) {
+	if strings.HasPrefix(address, "unix:") {
+		return net.DialTimeout("unix", strings.TrimPrefix(address, "unix:"), timeout)
+	}
+	if strings.HasPrefix(address, "/") {
+		return net.DialTimeout("unix", address, timeout)
+	}
+	return net.DialTimeout("tcp", address, timeout)
+}
+
+// fastcgiGet sends a GET request for scriptName to the FastCGI application
+// listening on address, without going through a web server.
+func fastcgiGet(address string, scriptName string, query string, timeout time.Duration) (*fcgiResponse, error) {
+	conn, err := fastcgiDial(address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	requestURI := scriptName
+	if query != "" {
+		requestURI += "?" + query
+	}
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REQUEST_METHOD":    "GET",
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   scriptName,
+		"QUERY_STRING":      query,
+		"REQUEST_URI":       requestURI,
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"SERVER_SOFTWARE":   integrationName,
+		"REMOTE_ADDR":       "127.0.0.1",
+	}
+
+	var request bytes.Buffer
+	beginBody := []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}
+	if err = writeRecord(&request, fcgiBeginRequest, beginBody); err != nil {
+		return nil, err
+	}
+	if err = writeRecord(&request, fcgiParams, encodeParams(params)); err != nil {
+		return nil, err
+	}
+	// Empty PARAMS and STDIN records close both streams
+	if err = writeRecord(&request, fcgiParams, nil); err != nil {
+		return nil, err
+	}
+	if err = writeRecord(&request, fcgiStdin, nil); err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(request.Bytes()); err != nil {
+		return nil, err
+	}
+
+	stdout, stderr, err := readResponse(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(stdout) == 0 && len(stderr) > 0 {
+		return nil, fmt.Errorf("FastCGI error: %s", strings.TrimSpace(string(stderr)))
+	}
+	return parseCGIResponse(stdout)
+}
+
+func writeRecord(w io.Writer, recordType uint8, content []byte) error {
+	for {
+		chunk := content
+		if len(chunk) > fcgiMaxContent {
+			chunk = content[:fcgiMaxContent]
+		}
+		padding := (8 - len(chunk)%8) % 8
+		header := fcgiHeader{
+			Version:       fcgiVersion,
+			Type:          recordType,
+			RequestID:     fcgiRequestID,
+			ContentLength: uint16(len(chunk)),
+			PaddingLength: uint8(padding),
+		}
+		if err := binary.Write(w, binary.BigEndian, header); err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		if _, err := w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+
+		content = content[len(chunk):]
+		if len(content) == 0 {
+			return nil
+		}
+	}
+}
+
+// encodeParams serializes name-value pairs using the FastCGI length encoding
+func encodeParams(params map[string]string) []byte {
+	var buf bytes.Buffer
+	for name, value := range params {
+		writeParamLength(&buf, len(name))
+		writeParamLength(&buf, len(value))
+		buf.WriteString(name)
+		buf.WriteString(value)
+	}
+	return buf.Bytes()
+}
+
+func writeParamLength(buf *bytes.Buffer, length int) {
+	if length < 128 {
+		buf.WriteByte(byte(length))
+		return
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(length)|1<<31)
+	buf.Write(b)
+}
+
+// readResponse reads records until the end of the request and returns the
+// contents of the STDOUT and STDERR streams.
+func readResponse(r io.Reader) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	reader := bufio.NewReader(r)
+
+	for {
+		var header fcgiHeader
+		if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+			if err == io.EOF {
+				return nil, nil, fmt.Errorf("FastCGI connection closed before end of request")
+			}
+			return nil, nil, err
+		}
+		content := make([]byte, int(header.ContentLength)+int(header.PaddingLength))
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return nil, nil, err
+		}
+		content = content[:header.ContentLength]
+
+		switch header.Type {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		case fcgiEndRequest:
+			return stdout.Bytes(), stderr.Bytes(), nil
+		}
+	}
+}
+
+// parseCGIResponse splits a CGI response in its headers and body
+func parseCGIResponse(raw []byte) (*fcgiResponse, error) {
+	reader := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Invalid FastCGI response headers: %s", err)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &fcgiResponse{
+		StatusCode: 200,
+		Header:     header,
+		Body:       body,
+	}
+	if status := header.Get("Status"); status != "" {
+		code, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid FastCGI response status '%s'", status)
+		}
+		response.StatusCode = code
+	}
+	return response, nil
+}
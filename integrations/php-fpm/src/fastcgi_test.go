@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fpmResponder stands in for a PHP-FPM pool, answering the status and ping
+// paths over FastCGI.
+func fpmResponder(t *testing.T, network string, address string) net.Listener {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		env := fcgi.ProcessEnv(r)
+		if env["SCRIPT_FILENAME"] != "/status" {
+			http.Error(w, "File not found.", http.StatusNotFound)
+			return
+		}
+		if _, ok := r.URL.Query()["json"]; !ok {
+			http.Error(w, "json expected", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(testPhpFpmJSONStatus))
+	})
+	handler.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong\n"))
+	})
+	go fcgi.Serve(listener, handler)
+
+	return listener
+}
+
+func TestFastcgiGetOverTCP(t *testing.T) {
+	listener := fpmResponder(t, "tcp", "127.0.0.1:0")
+	defer listener.Close()
+
+	resp, err := fastcgiGet(listener.Addr().String(), "/status", "json", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Error()
+	}
+	if string(resp.Body) != testPhpFpmJSONStatus {
+		t.Errorf("unexpected body %q", resp.Body)
+	}
+
+	resp, err = fastcgiGet(listener.Addr().String(), "/missing", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 404 {
+		t.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+func TestFastcgiGetOverUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "php-fpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "www.sock")
+	listener := fpmResponder(t, "unix", socket)
+	defer listener.Close()
+
+	for _, address := range []string{socket, "unix:" + socket} {
+		resp, err := fastcgiGet(address, "/ping", "", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Body) != "pong\n" {
+			t.Errorf("unexpected body %q", resp.Body)
+		}
+	}
+}
+
+func TestGetMetricsDataOverFastcgi(t *testing.T) {
+	listener := fpmResponder(t, "tcp", "127.0.0.1:0")
+	defer listener.Close()
+
+	args = argumentList{
+		FastcgiAddress: listener.Addr().String(),
+		StatusPath:     "/status",
+		PingPath:       "/ping",
+		PingResponse:   "pong",
+	}
+	defer func() { args = argumentList{} }()
+
+	rawMetrics, _, err := getMetricsData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatusMetrics(t, rawMetrics)
+	if rawMetrics["ping"] != 1 {
+		t.Error()
+	}
+
+	args.PingResponse = "ok"
+	rawMetrics, _, err = getMetricsData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawMetrics["ping"] != 0 {
+		t.Error()
+	}
+}
+
+func TestEncodeLongParams(t *testing.T) {
+	value := strings.Repeat("x", 200)
+	encoded := encodeParams(map[string]string{"NAME": value})
+
+	expected := append([]byte{4, 0x80, 0, 0, 200}, []byte("NAME"+value)...)
+	if !bytes.Equal(encoded, expected) {
+		t.Error()
+	}
+}
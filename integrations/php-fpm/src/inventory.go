@@ -5,84 +5,156 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/newrelic/infra-integrations-sdk/sdk"
 )
 
-func populateInventory(reader *bufio.Reader, inventory map[string]sdk.Inventory) error {
-	var curCmd string
-	var curValue string
+// maxIncludeDepth protects against include loops in the configuration files
+const maxIncludeDepth = 10
 
-	prefix := make([]string, 0, 10)
-	lineNo := 1
+// fpmConfig holds the directives of the [global] section of php-fpm.conf and
+// of every pool section found in it or in its included files.
+type fpmConfig struct {
+	global    map[string]string
+	pools     map[string]map[string]string
+	poolNames []string
+}
 
-	for {
-		r, _, err := reader.ReadRune()
-		if err == io.EOF {
-			return nil
+func newFpmConfig() *fpmConfig {
+	return &fpmConfig{
+		global:    make(map[string]string),
+		pools:     make(map[string]map[string]string),
+		poolNames: make([]string, 0),
+	}
+}
+
+func (config *fpmConfig) section(name string) map[string]string {
+	if name == "global" {
+		return config.global
+	}
+	pool, ok := config.pools[name]
+	if !ok {
+		pool = make(map[string]string)
+		config.pools[name] = pool
+		config.poolNames = append(config.poolNames, name)
+	}
+	return pool
+}
+
+// parseConfig reads an INI-style PHP-FPM configuration from reader. Relative
+// `include` patterns are resolved against dir.
+func parseConfig(reader *bufio.Reader, dir string, config *fpmConfig, depth int) error {
+	section := config.global
+
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
 		}
 
-		switch r {
-		case '{':
-			// parse start section
-			if curValue != "" {
-				curValue = strings.Replace(curValue, "/", ":", -1)
-				prefix = append(prefix, fmt.Sprintf("%s:%s", curCmd, strings.Trim(curValue, " \t")))
-			} else {
-				prefix = append(prefix, curCmd)
-			}
-			curCmd = ""
-			curValue = ""
-		case '}':
-			// parse end section
-			closeIdx := len(prefix) - 1
-			if closeIdx < 0 {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", line[0] == ';', line[0] == '#':
+			// ignore blank lines and comments
+		case line[0] == '[':
+			end := strings.Index(line, "]")
+			if end < 0 {
 				return fmt.Errorf("Error parsing config file in Line %d", lineNo)
 			}
-			prefix = prefix[:closeIdx]
-		case ';':
-			// parse end statement
-			prefix = append(prefix, curCmd)
-			inventory[strings.Join(prefix, "/")] = sdk.Inventory{"value": curValue}
-			prefix = prefix[:len(prefix)-1]
-
-			curValue = ""
-			curCmd = ""
-		case '\n':
-			// parse end line and ignore spaces
-			for r == '\n' || r == ' ' || r == '\t' {
-				r, _, _ = reader.ReadRune()
-			}
-			lineNo++
-			reader.UnreadRune()
-		case '#':
-			// ignore comments
-			for r != '\n' {
-				r, _, _ = reader.ReadRune()
+			section = config.section(strings.TrimSpace(line[1:end]))
+		default:
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("Error parsing config file in Line %d", lineNo)
 			}
-		case '\t', ' ':
-			if curValue == "" {
+			key := strings.TrimSpace(parts[0])
+			value := iniValue(parts[1])
+
+			if key == "include" {
+				if depth >= maxIncludeDepth {
+					return fmt.Errorf("Too many nested includes in Line %d", lineNo)
+				}
+				if err := includeConfig(value, dir, config, depth+1); err != nil {
+					return err
+				}
 				continue
 			}
-			if curValue != "" && curCmd == "" {
-				curCmd = curValue
-				curValue = ""
-			} else {
-				curValue += string(r)
-			}
-		default:
-			curValue += string(r)
+			section[key] = value
+		}
+
+		if err == io.EOF {
+			return nil
 		}
 	}
 }
 
-func getInventoryData(inventory map[string]sdk.Inventory) error {
-	f, err := os.Open(args.ConfigPath)
+// iniValue removes surrounding quotes and trailing comments from a raw value
+func iniValue(raw string) string {
+	value := strings.TrimSpace(raw)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	if idx := strings.Index(value, ";"); idx >= 0 {
+		value = strings.TrimSpace(value[:idx])
+	}
+	return value
+}
+
+// includeConfig parses every file matching the glob pattern, in lexical order
+// as PHP-FPM does.
+func includeConfig(pattern string, dir string, config *fpmConfig, depth int) error {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := readConfigFile(path, config, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readConfigFile(path string, config *fpmConfig, depth int) error {
+	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	return populateInventory(bufio.NewReader(f), inventory)
+	if err := parseConfig(bufio.NewReader(f), filepath.Dir(path), config, depth); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+func populateInventory(config *fpmConfig, inventory sdk.Inventory) {
+	for key, value := range config.global {
+		inventory.SetItem(fmt.Sprintf("global/%s", key), "value", value)
+	}
+	for name, pool := range config.pools {
+		for key, value := range pool {
+			inventory.SetItem(fmt.Sprintf("pool/%s/%s", name, key), "value", value)
+		}
+	}
+}
+
+func setInventoryData(inventory sdk.Inventory) error {
+	config := newFpmConfig()
+	if err := readConfigFile(args.ConfigPath, config, 0); err != nil {
+		return err
+	}
+
+	populateInventory(config, inventory)
+	return nil
 }
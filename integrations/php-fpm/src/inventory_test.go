@@ -2,6 +2,9 @@ package main
 
 import (
 	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -9,76 +12,134 @@ import (
 )
 
 var (
-	testNginxConf = `
-# This is a comment that should be ignored
+	testPhpFpmConf = `
+;;;;;;;;;;;;;;;;;;;;;
+; FPM Configuration ;
+;;;;;;;;;;;;;;;;;;;;;
 
-user nginx;
-worker_processes auto;
-error_log /var/log/nginx/error.log;
-pid /run/nginx.pid;
+; This is a comment that should be ignored
+include=pool.d/*.conf
 
-events {
-  worker_connections 1024;
-}
+[global]
+pid = /run/php-fpm/php-fpm.pid
+error_log = "/var/log/php-fpm/error.log" ; inline comment
+daemonize = yes
+`
+
+	testPhpFpmWwwPool = `[www]
+user = apache
+listen = 127.0.0.1:9000
+listen.allowed_clients = 127.0.0.1
+pm = dynamic
+pm.max_children = 50
+pm.status_path = /status
+php_admin_value[error_log] = /var/log/php-fpm/www-error.log
+`
 
-http {
-  log_format  main  '$remote_addr - $remote_user [$time_local] "$request" '
-                    '$status $body_bytes_sent "$http_referer" '
-                    '"$http_user_agent" "$http_x_forwarded_for"';
-  access_log  /var/log/nginx/access.log  main;
-
-  sendfile            on;
-  tcp_nopush          on;
-  tcp_nodelay         on;
-  keepalive_timeout   65;
-  types_hash_max_size 2048;
-
-  default_type        application/octet-stream;
-
-  server {
-    listen       80 default_server;
-    listen       [::]:80 default_server;
-    server_name  www.example.com;
-    root         /usr/share/nginx/html;
-
-    location / {
-    }
-    error_page 404 /404.html;
-      location = /40x.html {
-    }
-    error_page 500 502 503 504 /50x.html;
-      location = /50x.html {
-    }
-
-    location /status {
-      stub_status on;
-      access_log off;
-      allow 192.168.100.0/24;
-      deny all;
-    }
-  }
-}`
+	testPhpFpmApiPool = `[api]
+listen = /run/php-fpm/api.sock
+pm = static
+pm.max_children = 8
+`
 )
 
-func TestParseNginxConf(t *testing.T) {
-	inventory := make(map[string]sdk.Inventory)
-	err := populateInventory(bufio.NewReader(strings.NewReader(testNginxConf)), inventory)
+func TestParsePhpFpmConf(t *testing.T) {
+	config := newFpmConfig()
+	err := parseConfig(bufio.NewReader(strings.NewReader(testPhpFpmWwwPool+testPhpFpmApiPool)), ".", config, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := make(sdk.Inventory)
+	populateInventory(config, inventory)
 
+	if inventory["pool/www/pm.max_children"]["value"] != "50" {
+		t.Error()
+	}
+	if inventory["pool/www/php_admin_value[error_log]"]["value"] != "/var/log/php-fpm/www-error.log" {
+		t.Error()
+	}
+	if inventory["pool/api/listen"]["value"] != "/run/php-fpm/api.sock" {
+		t.Error()
+	}
+	if len(config.poolNames) != 2 || config.poolNames[0] != "www" || config.poolNames[1] != "api" {
+		t.Error()
+	}
+}
+
+func TestParsePhpFpmConfWithIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "php-fpm")
 	if err != nil {
-		t.Fatal()
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = os.Mkdir(filepath.Join(dir, "pool.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"php-fpm.conf":        testPhpFpmConf,
+		"pool.d/www.conf":     testPhpFpmWwwPool,
+		"pool.d/api.conf":     testPhpFpmApiPool,
+		"pool.d/api.conf.bak": "[ignored]\npm = static\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	if inventory["pid"]["value"] != "/run/nginx.pid" {
+	config := newFpmConfig()
+	if err = readConfigFile(filepath.Join(dir, "php-fpm.conf"), config, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := make(sdk.Inventory)
+	populateInventory(config, inventory)
+
+	if inventory["global/pid"]["value"] != "/run/php-fpm/php-fpm.pid" {
+		t.Error()
+	}
+	if inventory["global/error_log"]["value"] != "/var/log/php-fpm/error.log" {
+		t.Error()
+	}
+	if inventory["pool/www/pm.status_path"]["value"] != "/status" {
+		t.Error()
+	}
+	if inventory["pool/api/pm"]["value"] != "static" {
 		t.Error()
 	}
-	if inventory["events/worker_connections"]["value"] != "1024" {
+	if _, ok := inventory["pool/ignored/pm"]; ok {
 		t.Error()
 	}
-	if inventory["http/server/server_name"]["value"] != "www.example.com" {
+	// Included files are parsed in lexical order
+	if len(config.poolNames) != 2 || config.poolNames[0] != "api" {
 		t.Error()
 	}
-	if inventory["http/server/location::status/allow"]["value"] != "192.168.100.0/24" {
+}
+
+func TestParsePhpFpmConfWithIncludeLoop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "php-fpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "php-fpm.conf")
+	if err = ioutil.WriteFile(path, []byte("include = php-fpm.conf\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = readConfigFile(path, newFpmConfig(), 0); err == nil {
 		t.Error()
 	}
+}
 
+func TestParseBadPhpFpmConf(t *testing.T) {
+	for _, conf := range []string{"[www\nlisten = 9000\n", "[www]\nthis line has no value\n"} {
+		err := parseConfig(bufio.NewReader(strings.NewReader(conf)), ".", newFpmConfig(), 0)
+		if err == nil {
+			t.Error()
+		}
+	}
 }
@@ -2,130 +2,258 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"regexp"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/newrelic/infra-integrations-sdk/metric"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+	"github.com/newrelic/infra-integrations/pkg/sampler"
 )
 
-var metricsPlusDefinition = map[string][]interface{}{
-	"provider.connectionsActive":            {"connections.active", metric.GAUGE},
-	"provider.connectionsIdle":              {"connections.idle", metric.GAUGE},
-	"provider.connectionsAcceptedPerSecond": {"connections.accepted", metric.COUNTER},
-	"provider.connectionsDroppedPerSecond":  {"connections.dropped", metric.COUNTER},
-	"provider.requestsPerSecond":            {"requests.total", metric.COUNTER},
-	"software.edition":                      {"edition", metric.ATTRIBUTE},
-	"software.version":                      {"version", metric.ATTRIBUTE},
+var metricsDefinition = map[string][]interface{}{
+	"pool.name":                           {"pool", metric.ATTRIBUTE},
+	"pool.processManager":                 {"process manager", metric.ATTRIBUTE},
+	"net.connectionsAcceptedPerSecond":    {"accepted conn", metric.RATE},
+	"net.listenQueue":                     {"listen queue", metric.GAUGE},
+	"net.maxListenQueue":                  {"max listen queue", metric.GAUGE},
+	"net.listenQueueLength":               {"listen queue len", metric.GAUGE},
+	"process.idle":                        {"idle processes", metric.GAUGE},
+	"process.active":                      {"active processes", metric.GAUGE},
+	"process.total":                       {"total processes", metric.GAUGE},
+	"process.maxActive":                   {"max active processes", metric.GAUGE},
+	"process.maxChildrenReachedPerSecond": {"max children reached", metric.RATE},
+	"process.slowRequestsPerSecond":       {"slow requests", metric.RATE},
 }
 
-var metricsStandardDefinition = map[string][]interface{}{
-	"provider.connectionsActive":            {"active", metric.GAUGE},
-	"provider.reading":                      {"reading", metric.GAUGE},
-	"provider.waiting":                      {"waiting", metric.GAUGE},
-	"provider.writing":                      {"writing", metric.GAUGE},
-	"provider.requestsPerSecond":            {"requests", metric.COUNTER},
-	"provider.connectionsAcceptedPerSecond": {"accepted", metric.COUNTER},
-	"provider.connectionsDroppedPerSecond":  {connectionsDroppedPerSecond, metric.COUNTER},
-	"software.edition":                      {"edition", metric.ATTRIBUTE},
-	"software.version":                      {"version", metric.ATTRIBUTE},
+var pingMetricsDefinition = map[string][]interface{}{
+	"net.pingSucceeded": {"ping", metric.GAUGE},
 }
 
-// expressions contains the structure of the input data and defines the attributes we want to store
-var nginxStatusExpressions = []*regexp.Regexp{
-	regexp.MustCompile(`Active connections:\s+(?P<active>\d+)`),
-	nil,
-	regexp.MustCompile(`\s*(?P<accepted>\d+)\s+(?P<handled>\d+)\s+(?P<requests>\d+)`),
-	regexp.MustCompile(`Reading: (?P<reading>\d+)\s+Writing: (?P<writing>\d+)\s+Waiting: (?P<waiting>\d+)`),
+var fullMetricsDefinition = map[string][]interface{}{
+	"process.longRunning":                        {"long running processes", metric.GAUGE},
+	"process.longestRequestDurationMilliseconds": {"longest request duration", metric.GAUGE},
 }
 
-func connectionsDroppedPerSecond(metrics map[string]interface{}) (int, bool) {
-	accepts, ok1 := metrics["accepted"].(int)
-	handled, ok2 := metrics["handled"].(int)
+var processMetricsDefinition = map[string][]interface{}{
+	"pool.name":                           {"pool", metric.ATTRIBUTE},
+	"process.pid":                         {"pid", metric.ATTRIBUTE},
+	"process.state":                       {"state", metric.ATTRIBUTE},
+	"process.startTime":                   {"start time", metric.ATTRIBUTE},
+	"process.startSinceSeconds":           {"start since", metric.GAUGE},
+	"process.requestsServed":              {"requests", metric.GAUGE},
+	"process.requestDurationMilliseconds": {requestDurationMilliseconds, metric.GAUGE},
+	"process.requestMethod":               {"request method", metric.ATTRIBUTE},
+	"process.requestUri":                  {"request uri", metric.ATTRIBUTE},
+	"process.script":                      {"script", metric.ATTRIBUTE},
+	"process.lastRequestCpuPercent":       {"last request cpu", metric.GAUGE},
+	"process.lastRequestMemoryBytes":      {"last request memory", metric.GAUGE},
+}
 
-	if ok1 && ok2 {
-		return accepts - handled, true
+// FPM reports the request duration in microseconds
+func requestDurationMilliseconds(metrics map[string]interface{}) (int, bool) {
+	duration, ok := metrics["request duration"].(int)
+	if !ok {
+		return 0, false
 	}
-	return 0, false
+	return duration / 1000, true
 }
 
-// getMetrics reads an NGINX (open edition) status message and transforms its
-// contents into a map that can be processed by NR agent.
-// It returns a map of metrics with all the keys and values extracted from the
-// status endpoint.
-func getStandardMetrics(reader *bufio.Reader) (map[string]interface{}, error) {
-	metrics := make(map[string]interface{})
-	for lineNo, re := range nginxStatusExpressions {
-		line, err := reader.ReadString('\n')
+// getStatusMetrics reads a PHP-FPM status page, either in its JSON (`?json`)
+// or its default plain-text format, and transforms its contents into a map
+// that can be processed by NR agent.
+// It returns a map of metrics keyed by the field names of the status page.
+func getStatusMetrics(reader *bufio.Reader) (map[string]interface{}, error) {
+	metrics, _, err := getFullStatusMetrics(reader)
+	return metrics, err
+}
 
+// getFullStatusMetrics reads a PHP-FPM status page like getStatusMetrics and,
+// when the page was requested in `full` mode, also returns the metrics of
+// every worker process. Process maps are tagged with the name of their pool
+// and have their keys lowercased, since the plain-text format uses
+// "request URI" where the JSON format uses "request uri".
+func getFullStatusMetrics(reader *bufio.Reader) (map[string]interface{}, []map[string]interface{}, error) {
+	var metrics map[string]interface{}
+	var processes []map[string]interface{}
+	var err error
+
+	// Skip leading blanks to find out which format the page is in
+	r := ' '
+	for r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+		r, _, err = reader.ReadRune()
 		if err == io.EOF {
-			return metrics, nil
+			return nil, nil, fmt.Errorf("Empty status page")
+		} else if err != nil {
+			return nil, nil, err
 		}
+	}
+	reader.UnreadRune()
 
-		if re == nil {
-			continue
-		}
+	if r == '{' {
+		metrics, processes, err = getJSONStatusMetrics(reader)
+	} else {
+		metrics, processes, err = getPlainStatusMetrics(reader)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
 
-		match := re.FindStringSubmatch(line)
-		if match == nil {
-			return nil, fmt.Errorf("Line %d of status doesn't match", lineNo)
+	for _, process := range processes {
+		process["pool"] = metrics["pool"]
+		if pid, ok := process["pid"]; ok {
+			process["pid"] = fmt.Sprintf("%v", pid)
 		}
-
-		for i, name := range re.SubexpNames() {
-			if i != 0 {
-				value, err := strconv.Atoi(match[i])
-				if err != nil {
-					log.Warn("Can't cast value '%s'", match[i])
-					continue
-				}
-				metrics[name] = value
-			}
+		if startTime, ok := process["start time"]; ok {
+			process["start time"] = fmt.Sprintf("%v", startTime)
 		}
 	}
-	metrics["version"] = ""
-	metrics["edition"] = "open source"
-
-	return metrics, nil
+	return metrics, processes, nil
 }
 
-// getPlusMetrics reads an NGINX (Plus edition) status message, gets some
-// metrics and transforms the contents into a map that can be processed by NR
-// agent.
-// It returns a map of metrics keys -> values.
-func getPlusMetrics(reader *bufio.Reader) (map[string]interface{}, error) {
+// getJSONStatusMetrics decodes the `?json` flavour of the status page.
+func getJSONStatusMetrics(reader *bufio.Reader) (map[string]interface{}, []map[string]interface{}, error) {
 	jsonMetrics := make(map[string]interface{})
-	metrics := make(map[string]interface{})
 
 	dec := json.NewDecoder(reader)
 	err := dec.Decode(&jsonMetrics)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	roots := [2]string{"connections", "requests"}
+	metrics := jsonValues(jsonMetrics, false)
+	processes := make([]map[string]interface{}, 0)
 
-	for _, rootKey := range roots {
-		rootNode, ok := jsonMetrics[rootKey].(map[string]interface{})
+	rawProcesses, ok := jsonMetrics["processes"].([]interface{})
+	if ok {
+		for _, rawProcess := range rawProcesses {
+			process, ok := rawProcess.(map[string]interface{})
+			if !ok {
+				log.Warn("Can't assert type for process %v", rawProcess)
+				continue
+			}
+			processes = append(processes, jsonValues(process, true))
+		}
+	}
+	return metrics, processes, nil
+}
+
+// jsonValues keeps the scalar values of a decoded JSON object, converting
+// integral numbers to int.
+func jsonValues(jsonMetrics map[string]interface{}, lowerKeys bool) map[string]interface{} {
+	metrics := make(map[string]interface{})
+
+	for key, value := range jsonMetrics {
+		if lowerKeys {
+			key = strings.ToLower(key)
+		}
+		switch v := value.(type) {
+		case float64:
+			if v == float64(int(v)) {
+				metrics[key] = int(v)
+			} else {
+				metrics[key] = v
+			}
+		case string:
+			metrics[key] = v
+		}
+	}
+	return metrics
+}
+
+// getPlainStatusMetrics parses the default plain-text status page, made of
+// `name: value` lines. In `full` mode every worker process is listed after
+// the pool values, each one of them preceded by a line of asterisks.
+func getPlainStatusMetrics(reader *bufio.Reader) (map[string]interface{}, []map[string]interface{}, error) {
+	metrics := make(map[string]interface{})
+	processes := make([]map[string]interface{}, 0)
+	current := metrics
+
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "*") {
+			current = make(map[string]interface{})
+			processes = append(processes, current)
+		} else if line != "" {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 {
+				return nil, nil, fmt.Errorf("Line %d of status doesn't match", lineNo)
+			}
+			key := strings.TrimSpace(parts[0])
+			if len(processes) > 0 {
+				key = strings.ToLower(key)
+			}
+			current[key] = asValue(strings.TrimSpace(parts[1]))
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if len(metrics) == 0 {
+		return nil, nil, fmt.Errorf("Empty status page")
+	}
+	return metrics, processes, nil
+}
+
+// summarizeProcesses adds to the pool metrics the number of workers that
+// have been serving the same request for longer than threshold, and the
+// longest duration of a request in progress, so that hung PHP processes do
+// not go unnoticed.
+func summarizeProcesses(metrics map[string]interface{}, processes []map[string]interface{}, threshold time.Duration) {
+	longRunning := 0
+	longest := 0
+
+	for _, process := range processes {
+		if process["state"] == "Idle" {
+			continue
+		}
+		duration, ok := process["request duration"].(int)
 		if !ok {
-			log.Warn("Can't assert type for %s", rootNode)
 			continue
 		}
-		for key, value := range rootNode {
-			metrics[fmt.Sprintf("%s.%s", rootKey, key)] = int(value.(float64))
+		if time.Duration(duration)*time.Microsecond >= threshold {
+			longRunning++
+		}
+		if duration > longest {
+			longest = duration
 		}
 	}
-	metrics["version"] = jsonMetrics["nginx_version"]
-	metrics["edition"] = "plus"
-	return metrics, nil
+	metrics["long running processes"] = longRunning
+	metrics["longest request duration"] = longest / 1000
+}
+
+// asValue converts a string to a number or returns the string if not possible
+func asValue(value string) interface{} {
+	if i, err := strconv.Atoi(value); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return value
 }
 
 func populateMetrics(sample *metric.MetricSet, metrics map[string]interface{}, metricsDefinition map[string][]interface{}) error {
+	return populatePoolMetrics(sample, metrics, metricsDefinition, "")
+}
+
+// populatePoolMetrics works like populateMetrics, but samples the RATE and
+// DELTA metrics of a pool under its namespace, apart from the other pools.
+func populatePoolMetrics(sample *metric.MetricSet, metrics map[string]interface{}, metricsDefinition map[string][]interface{}, namespace string) error {
 	for metricName, metricInfo := range metricsDefinition {
 		rawSource := metricInfo[0]
 		metricType := metricInfo[1].(metric.SourceType)
@@ -147,7 +275,17 @@ func populateMetrics(sample *metric.MetricSet, metrics map[string]interface{}, m
 			log.Warn("Can't find raw metrics in results for %s", metricName)
 			continue
 		}
-		err := sample.AddMetric(metricName, rawMetric, metricType)
+
+		if namespace != "" && (metricType == metric.RATE || metricType == metric.DELTA) {
+			sampled, err := sampler.Sample(sampler.Key(namespace, metricName), rawMetric, metricType)
+			if err != nil {
+				log.Warn("Error setting value: %s", err)
+				continue
+			}
+			rawMetric = sampled
+			metricType = metric.GAUGE
+		}
+		err := sample.SetMetric(metricName, rawMetric, metricType)
 
 		if err != nil {
 			log.Warn("Error setting value: %s", err)
@@ -157,30 +295,134 @@ func populateMetrics(sample *metric.MetricSet, metrics map[string]interface{}, m
 	return nil
 }
 
-func getMetricsData(sample *metric.MetricSet) error {
-	netClient := &http.Client{
-		Timeout: time.Second * 1,
+// statusQuery appends the `full` parameter to the query string of the status
+// page when per-process metrics are enabled.
+func statusQuery(query string) string {
+	if !args.Full {
+		return query
 	}
-	resp, err := netClient.Get(args.StatusURL)
+	if query == "" {
+		return "full"
+	}
+	return query + "&full"
+}
+
+// statusURL returns the configured status URL, asking for the `full` page
+// when per-process metrics are enabled.
+func statusURL() (string, error) {
+	u, err := url.Parse(args.StatusURL)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer resp.Body.Close()
-	var rawMetrics map[string]interface{}
-	var metricsDefinition map[string][]interface{}
+	u.RawQuery = statusQuery(u.RawQuery)
+	return u.String(), nil
+}
 
-	if resp.Header.Get("content-type") == "application/json" {
-		metricsDefinition = metricsPlusDefinition
-		rawMetrics, err = getPlusMetrics(bufio.NewReader(resp.Body))
-	} else {
-		metricsDefinition = metricsStandardDefinition
-		rawMetrics, err = getStandardMetrics(bufio.NewReader(resp.Body))
-		rawVersion := strings.Replace(resp.Header.Get("Server"), "nginx/", "", -1)
-		rawMetrics["version"] = rawVersion
+// getFastcgiStatus requests the JSON status page straight from the FastCGI
+// listener of the pool.
+func getFastcgiStatus(pool fpmPool) ([]byte, error) {
+	resp, err := fastcgiGet(pool.address, pool.statusPath, statusQuery("json"), requestTimeout())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected response status from %s%s: %d", pool.address, pool.statusPath, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// pingFastcgi returns 1 if the pool answers its ping path with the expected
+// response and 0 otherwise.
+func pingFastcgi(pool fpmPool) int {
+	resp, err := fastcgiGet(pool.address, pool.pingPath, "", requestTimeout())
+	if err != nil {
+		log.Warn("Can't ping %s%s: %s", pool.address, pool.pingPath, err)
+		return 0
+	}
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(resp.Body)) != pool.pingResponse {
+		log.Warn("Unexpected ping response from %s%s", pool.address, pool.pingPath)
+		return 0
+	}
+	return 1
+}
+
+// getPoolMetricsData queries the status page, and the ping path if any, of a
+// pool over FastCGI.
+func getPoolMetricsData(pool fpmPool) (map[string]interface{}, []map[string]interface{}, error) {
+	body, err := getFastcgiStatus(pool)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	rawMetrics, processes, err := getFullStatusMetrics(bufio.NewReader(bytes.NewReader(body)))
+	if err != nil {
+		return nil, nil, err
+	}
+	if args.Full {
+		summarizeProcesses(rawMetrics, processes, time.Duration(args.LongRequestThreshold)*time.Second)
+	}
+	if pool.pingPath != "" {
+		rawMetrics["ping"] = pingFastcgi(pool)
+	}
+	return rawMetrics, processes, nil
+}
+
+func getMetricsData() (map[string]interface{}, []map[string]interface{}, error) {
+	if args.FastcgiAddress != "" {
+		return getPoolMetricsData(fpmPool{
+			address:      args.FastcgiAddress,
+			statusPath:   args.StatusPath,
+			pingPath:     args.PingPath,
+			pingResponse: args.PingResponse,
+		})
+	}
+
+	target, err := statusURL()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	netClient, err := newHTTPClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := netClient.Get(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("Unexpected response status from %s: %s", target, resp.Status)
 	}
+
+	rawMetrics, processes, err := getFullStatusMetrics(bufio.NewReader(resp.Body))
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	if args.Full {
+		summarizeProcesses(rawMetrics, processes, time.Duration(args.LongRequestThreshold)*time.Second)
+	}
+	return rawMetrics, processes, nil
+}
+
+// populateSamples reports the PhpFpmSample of a pool and, in full mode, a
+// PhpFpmProcessSample per worker. When more than one pool is monitored,
+// namespace must identify the pool so its rates are computed apart from the
+// other pools' ones.
+func populateSamples(integration *sdk.Integration, rawMetrics map[string]interface{}, processes []map[string]interface{}, namespace string) {
+	sample := integration.NewMetricSet("PhpFpmSample")
+	populatePoolMetrics(sample, rawMetrics, metricsDefinition, namespace)
+
+	if _, ok := rawMetrics["ping"]; ok {
+		populateMetrics(sample, rawMetrics, pingMetricsDefinition)
+	}
+
+	if args.Full {
+		populateMetrics(sample, rawMetrics, fullMetricsDefinition)
+		for _, processMetrics := range processes {
+			ms := integration.NewMetricSet("PhpFpmProcessSample")
+			populateMetrics(ms, processMetrics, processMetricsDefinition)
+		}
 	}
-	return populateMetrics(sample, rawMetrics, metricsDefinition)
 }
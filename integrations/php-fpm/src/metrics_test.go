@@ -4,118 +4,276 @@ import (
 	"bufio"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/metric"
 )
 
-var testNginxStandardStatus = `Active connections: 291
-server accepts handled requests
- 16630948 16630948 31070465
-Reading: 6 Writing: 179 Waiting: 106
+var testPhpFpmJSONStatus = `{"pool":"www","process manager":"dynamic","start time":1500282000,"start since":1234,"accepted conn":12073,"listen queue":1,"max listen queue":3,"listen queue len":128,"idle processes":4,"active processes":1,"total processes":5,"max active processes":2,"max children reached":7,"slow requests":2}
+`
+
+var testPhpFpmPlainStatus = `pool:                 www
+process manager:      dynamic
+start time:           17/Jul/2017:10:00:00 +0000
+start since:          1234
+accepted conn:        12073
+listen queue:         1
+max listen queue:     3
+listen queue len:     128
+idle processes:       4
+active processes:     1
+total processes:      5
+max active processes: 2
+max children reached: 7
+slow requests:        2
 `
-var testBadNginxStandardStatus = `Active connections: 291
-server accepts handled requests
+
+var testBadPhpFpmPlainStatus = `pool:                 www
 this is an extra line that makes the parser fail
- 16630948 16630948 31070465
-Reading: 6 Writing: 179 Waiting: 106
+accepted conn:        12073
 `
 
-var testNginxPlusStatus = `{
-  "timestamp": 1490347905131,
-  "connections": {
-    "accepted": 4112716,
-    "dropped": 0,
-    "active": 6,
-    "idle": 41
-  },
-  "requests": {
-    "total": 9353067,
-    "current": 5
-  },
-  "nginx_version": "1.0"
+var testBadPhpFpmJSONStatus = `{`
+
+func checkStatusMetrics(t *testing.T, rawMetrics map[string]interface{}) {
+	if rawMetrics["pool"] != "www" {
+		t.Error()
+	}
+	if rawMetrics["process manager"] != "dynamic" {
+		t.Error()
+	}
+	if rawMetrics["accepted conn"] != 12073 {
+		t.Error()
+	}
+	if rawMetrics["listen queue"] != 1 {
+		t.Error()
+	}
+	if rawMetrics["max listen queue"] != 3 {
+		t.Error()
+	}
+	if rawMetrics["idle processes"] != 4 {
+		t.Error()
+	}
+	if rawMetrics["active processes"] != 1 {
+		t.Error()
+	}
+	if rawMetrics["total processes"] != 5 {
+		t.Error()
+	}
+	if rawMetrics["max children reached"] != 7 {
+		t.Error()
+	}
+	if rawMetrics["slow requests"] != 2 {
+		t.Error()
+	}
 }
-`
-var testBadNginxPlusStatus = `{`
 
-func TestGetPlusMetrics(t *testing.T) {
-	rawMetrics, err := getPlusMetrics(bufio.NewReader(strings.NewReader(testNginxPlusStatus)))
+func TestGetJSONStatusMetrics(t *testing.T) {
+	rawMetrics, err := getStatusMetrics(bufio.NewReader(strings.NewReader(testPhpFpmJSONStatus)))
 	if err != nil {
-		t.Fatal()
+		t.Fatal(err)
 	}
-
-	if len(rawMetrics) != 8 {
+	if len(rawMetrics) != 14 {
 		t.Error()
 	}
-	if rawMetrics["connections.accepted"] != 4112716 {
+	checkStatusMetrics(t, rawMetrics)
+}
+
+func TestGetPlainStatusMetrics(t *testing.T) {
+	rawMetrics, err := getStatusMetrics(bufio.NewReader(strings.NewReader(testPhpFpmPlainStatus)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rawMetrics) != 14 {
 		t.Error()
 	}
-	if rawMetrics["connections.dropped"] != 0 {
+	if rawMetrics["start time"] != "17/Jul/2017:10:00:00 +0000" {
 		t.Error()
 	}
-	if rawMetrics["connections.active"] != 6 {
+	checkStatusMetrics(t, rawMetrics)
+}
+
+func TestGetStatusMetricsWithInvalidData(t *testing.T) {
+	for _, status := range []string{testBadPhpFpmPlainStatus, testBadPhpFpmJSONStatus, ""} {
+		rawMetrics, err := getStatusMetrics(bufio.NewReader(strings.NewReader(status)))
+
+		if rawMetrics != nil {
+			t.Error()
+		}
+		if err == nil {
+			t.Error()
+		}
+	}
+}
+
+func TestPopulateMetrics(t *testing.T) {
+	rawMetrics, err := getStatusMetrics(bufio.NewReader(strings.NewReader(testPhpFpmJSONStatus)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := metric.NewMetricSet("PhpFpmSample")
+	populateMetrics(&sample, rawMetrics, metricsDefinition)
+
+	if sample["pool.name"] != "www" {
 		t.Error()
 	}
-	if rawMetrics["connections.idle"] != 41 {
+	if sample["pool.processManager"] != "dynamic" {
 		t.Error()
 	}
-	if rawMetrics["requests.total"] != 9353067 {
+	if sample["process.total"] != 5 {
 		t.Error()
 	}
-	if rawMetrics["version"] != "1.0" {
+	if sample["net.maxListenQueue"] != 3 {
 		t.Error()
 	}
-	if rawMetrics["edition"] != "plus" {
+	if _, ok := sample["net.connectionsAcceptedPerSecond"]; !ok {
 		t.Error()
 	}
 }
 
-func TestGetPlusMetricsWithInvalidData(t *testing.T) {
-	rawMetrics, err := getPlusMetrics(bufio.NewReader(strings.NewReader(testBadNginxPlusStatus)))
+var testPhpFpmFullJSONStatus = `{"pool":"www","process manager":"static","start time":1500282000,"start since":1234,"accepted conn":12073,"listen queue":0,"max listen queue":0,"listen queue len":128,"idle processes":1,"active processes":2,"total processes":3,"max active processes":3,"max children reached":0,"slow requests":0, "processes":[{"pid":31,"state":"Idle","start time":1500282000,"start since":1234,"requests":54,"request duration":95000000,"request method":"GET","request uri":"/index.php","content length":0,"user":"-","script":"/var/www/index.php","last request cpu":2.5,"last request memory":2097152},{"pid":32,"state":"Running","start time":1500282000,"start since":1234,"requests":40,"request duration":120000000,"request method":"POST","request uri":"/import.php","content length":512,"user":"-","script":"/var/www/import.php","last request cpu":0.00,"last request memory":0},{"pid":33,"state":"Running","start time":1500282000,"start since":1234,"requests":61,"request duration":1500,"request method":"GET","request uri":"/status?json&full","content length":0,"user":"-","script":"-","last request cpu":0.00,"last request memory":0}]}`
 
-	if rawMetrics != nil {
+var testPhpFpmFullPlainStatus = `pool:                 www
+process manager:      static
+start time:           17/Jul/2017:10:00:00 +0000
+start since:          1234
+accepted conn:        12073
+listen queue:         0
+max listen queue:     0
+listen queue len:     128
+idle processes:       1
+active processes:     1
+total processes:      2
+max active processes: 2
+max children reached: 0
+slow requests:        0
+
+************************
+pid:                  31
+state:                Idle
+start time:           17/Jul/2017:10:00:00 +0000
+start since:          1234
+requests:             54
+request duration:     95000000
+request method:       GET
+request URI:          /index.php
+content length:       0
+user:                 -
+script:               /var/www/index.php
+last request cpu:     2.50
+last request memory:  2097152
+
+************************
+pid:                  32
+state:                Running
+start time:           17/Jul/2017:10:00:00 +0000
+start since:          1234
+requests:             40
+request duration:     120000000
+request method:       POST
+request URI:          /import.php
+content length:       512
+user:                 -
+script:               /var/www/import.php
+last request cpu:     0.00
+last request memory:  0
+`
+
+func TestGetFullJSONStatusMetrics(t *testing.T) {
+	rawMetrics, processes, err := getFullStatusMetrics(bufio.NewReader(strings.NewReader(testPhpFpmFullJSONStatus)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawMetrics["total processes"] != 3 {
+		t.Error()
+	}
+	if len(processes) != 3 {
+		t.Fatal()
+	}
+	if processes[0]["pool"] != "www" {
+		t.Error()
+	}
+	if processes[0]["pid"] != "31" {
+		t.Error()
+	}
+	if processes[0]["last request cpu"] != 2.5 {
 		t.Error()
 	}
-	if err == nil {
+	if processes[1]["request uri"] != "/import.php" {
 		t.Error()
 	}
 }
 
-func TestGetStandardMetrics(t *testing.T) {
-	rawMetrics, err := getStandardMetrics(bufio.NewReader(strings.NewReader(testNginxStandardStatus)))
+func TestGetFullPlainStatusMetrics(t *testing.T) {
+	rawMetrics, processes, err := getFullStatusMetrics(bufio.NewReader(strings.NewReader(testPhpFpmFullPlainStatus)))
 	if err != nil {
-		t.Fatal()
+		t.Fatal(err)
 	}
-	if len(rawMetrics) != 9 {
+	if rawMetrics["total processes"] != 2 {
 		t.Error()
 	}
-	if rawMetrics["active"] != 291 {
+	if _, ok := rawMetrics["pid"]; ok {
+		t.Error("process values leaked into pool metrics")
+	}
+	if len(processes) != 2 {
+		t.Fatal()
+	}
+	if processes[1]["pool"] != "www" {
 		t.Error()
 	}
-	if rawMetrics["reading"] != 6 {
+	if processes[1]["pid"] != "32" {
 		t.Error()
 	}
-	if rawMetrics["waiting"] != 106 {
+	if processes[1]["state"] != "Running" {
 		t.Error()
 	}
-	if rawMetrics["writing"] != 179 {
+	if processes[1]["request uri"] != "/import.php" {
 		t.Error()
 	}
-	if rawMetrics["requests"] != 31070465 {
+	if processes[1]["request duration"] != 120000000 {
 		t.Error()
 	}
-	if rawMetrics["accepted"] != 16630948 {
+}
+
+func TestSummarizeProcesses(t *testing.T) {
+	rawMetrics, processes, err := getFullStatusMetrics(bufio.NewReader(strings.NewReader(testPhpFpmFullJSONStatus)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summarizeProcesses(rawMetrics, processes, 30*time.Second)
+
+	// The idle worker's last request was long, but it is not stuck anymore
+	if rawMetrics["long running processes"] != 1 {
 		t.Error()
 	}
-	if rawMetrics["handled"] != 16630948 {
+	if rawMetrics["longest request duration"] != 120000 {
 		t.Error()
 	}
 }
 
-func TestGetStandardMetricsWithInvalidData(t *testing.T) {
-	rawMetrics, err := getStandardMetrics(bufio.NewReader(strings.NewReader(testBadNginxStandardStatus)))
+func TestPopulateProcessMetrics(t *testing.T) {
+	_, processes, err := getFullStatusMetrics(bufio.NewReader(strings.NewReader(testPhpFpmFullPlainStatus)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := metric.NewMetricSet("PhpFpmProcessSample")
+	populateMetrics(&sample, processes[1], processMetricsDefinition)
 
-	if rawMetrics != nil {
+	if sample["pool.name"] != "www" {
+		t.Error()
+	}
+	if sample["process.pid"] != "32" {
+		t.Error()
+	}
+	if sample["process.requestDurationMilliseconds"] != 120000 {
+		t.Error()
+	}
+	if sample["process.requestUri"] != "/import.php" {
 		t.Error()
 	}
-	if err == nil {
+	if sample["process.lastRequestCpuPercent"] != float64(0) {
 		t.Error()
 	}
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	sdk_args "github.com/newrelic/infra-integrations-sdk/args"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/sdk"
+)
+
+type argumentList struct {
+	sdk_args.DefaultArgumentList
+	StatusURL             string        `default:"http://127.0.0.1/status" help:"PHP-FPM status page URL."`
+	ConfigPath            string        `default:"/etc/php-fpm.conf" help:"PHP-FPM configuration file."`
+	FastcgiAddress        string        `default:"" help:"Query the status page over FastCGI on this unix socket path or host:port instead of status_url."`
+	StatusPath            string        `default:"/status" help:"PHP-FPM status path (pm.status_path), used over FastCGI."`
+	PingPath              string        `default:"" help:"PHP-FPM ping path (ping.path), checked over FastCGI when set."`
+	PingResponse          string        `default:"pong" help:"Expected PHP-FPM ping response (ping.response)."`
+	DiscoverPools         bool          `default:"false" help:"Monitor over FastCGI every pool with a pm.status_path found in config_path."`
+	Full                  bool          `default:"false" help:"Query the full status page and report a PhpFpmProcessSample per worker."`
+	LongRequestThreshold  int           `default:"30" help:"Seconds after which a request in progress is considered long running (full mode)."`
+	CABundle              string        `default:"" help:"PEM file with the CAs trusted to verify the status URL certificate."`
+	ClientCert            string        `default:"" help:"PEM client certificate sent to the status URL."`
+	ClientKey             string        `default:"" help:"PEM key of the client certificate."`
+	TLSInsecureSkipVerify bool          `default:"false" help:"Don't verify the status URL certificate."`
+	Username              string        `default:"" help:"Basic auth username for the status URL."`
+	Password              string        `default:"" help:"Basic auth password for the status URL."`
+	Headers               sdk_args.JSON `default:"" help:"JSON object with headers added to the status URL requests, e.g. {\"Host\": \"status.local\"}."`
+	UnixSocket            string        `default:"" help:"Unix socket to connect to instead of the status URL host."`
+	Timeout               int           `default:"1" help:"Timeout in seconds of the status and ping requests."`
+}
+
+const (
+	integrationName    = "com.newrelic.php-fpm"
+	integrationVersion = "0.2.0"
+)
+
+var (
+	args argumentList
+)
+
+func main() {
+	integration, err := sdk.NewIntegration(integrationName, integrationVersion, &args)
+	fatalIfErr(err)
+	log.SetupLogging(args.Verbose)
+
+	if args.All || args.Inventory {
+		fatalIfErr(setInventoryData(integration.Inventory))
+	}
+
+	if args.All || args.Metrics {
+		if args.DiscoverPools {
+			pools, err := discoverPools()
+			fatalIfErr(err)
+
+			for _, pool := range pools {
+				rawMetrics, processes, err := getPoolMetricsData(pool)
+				if err != nil {
+					log.Warn("Can't get metrics for pool %s: %s", pool.name, err)
+					populatePoolError(integration, pool, err)
+					continue
+				}
+				populateSamples(integration, rawMetrics, processes, pool.name)
+			}
+		} else {
+			rawMetrics, processes, err := getMetricsData()
+			fatalIfErr(err)
+			populateSamples(integration, rawMetrics, processes, "")
+		}
+	}
+
+	fatalIfErr(integration.Publish())
+}
+
+func fatalIfErr(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,173 @@
+// Package httpclient builds the HTTP clients the integrations use to query
+// status endpoints, which may sit behind HTTPS with internal CAs, client
+// certificate authentication, basic auth or a unix socket.
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultTimeout is used when Options doesn't set a timeout
+const DefaultTimeout = time.Second * 1
+
+// Options configures the client returned by New. Zero values keep the
+// defaults of net/http.
+type Options struct {
+	// CABundle is a PEM file with the CAs trusted to verify the server
+	CABundle string
+	// ClientCert and ClientKey are the PEM files of the client certificate
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool
+	// Username and Password are sent as basic auth credentials when set
+	Username string
+	Password string
+	// Headers are added to every request
+	Headers map[string]string
+	// UnixSocket makes every request connect to this socket path, whatever the
+	// host of the URL is
+	UnixSocket string
+	Timeout    time.Duration
+}
+
+// New returns a client configured with opts
+func New(opts Options) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	if opts.UnixSocket != "" {
+		socket := opts.UnixSocket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		transport.Proxy = nil
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &requestDecorator{
+			transport: transport,
+			username:  opts.Username,
+			password:  opts.Password,
+			headers:   opts.Headers,
+		},
+	}, nil
+}
+
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+
+	if opts.CABundle != "" {
+		pem, err := ioutil.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read CA bundle: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA bundle %s", opts.CABundle)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, fmt.Errorf("Both a client certificate and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Can't load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// requestDecorator adds the credentials and headers to every request, but
+// the redirects to other hosts.
+type requestDecorator struct {
+	transport http.RoundTripper
+	username  string
+	password  string
+	headers   map[string]string
+}
+
+func (d *requestDecorator) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !sameHostAsOriginal(req) {
+		return d.transport.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request it was given
+	req = cloneRequest(req)
+	for name, value := range d.headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	if d.username != "" || d.password != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+	return d.transport.RoundTrip(req)
+}
+
+// sameHostAsOriginal tells whether a request goes to the host of the request
+// that led to it through redirects, so credentials don't leak to third
+// parties.
+func sameHostAsOriginal(req *http.Request) bool {
+	original := req
+	for original.Response != nil && original.Response.Request != nil {
+		original = original.Response.Request
+	}
+	return original.URL.Host == req.URL.Host
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for name, values := range req.Header {
+		clone.Header[name] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// HeadersFromJSON converts the value of a JSON argument, which must be an
+// object of strings, to request headers.
+func HeadersFromJSON(value interface{}) (map[string]string, error) {
+	headers := make(map[string]string)
+	if value == nil {
+		return headers, nil
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Headers must be a JSON object")
+	}
+	for name, raw := range object {
+		header, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("Value of header %s must be a string", name)
+		}
+		headers[name] = header
+	}
+	return headers, nil
+}
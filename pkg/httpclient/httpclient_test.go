@@ -0,0 +1,231 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	username, password, _ := r.BasicAuth()
+	fmt.Fprintf(w, "%s %s %s %s", r.Host, username, password, r.Header.Get("X-Token"))
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewWithBasicAuthAndHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer server.Close()
+
+	client, err := New(Options{
+		Username: "admin",
+		Password: "secret",
+		Headers:  map[string]string{"X-Token": "abc", "Host": "status.local"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := get(t, client, server.URL); body != "status.local admin secret abc" {
+		t.Error()
+	}
+	if client.Timeout != DefaultTimeout {
+		t.Error()
+	}
+}
+
+func TestNewDoesNotLeakCredentialsOnRedirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(echoHandler))
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", echoHandler)
+	mux.HandleFunc("/local", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/status", http.StatusFound)
+	})
+	mux.HandleFunc("/remote", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/status", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := New(Options{
+		Username: "admin",
+		Password: "secret",
+		Headers:  map[string]string{"X-Token": "abc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := server.Listener.Addr().String()
+	if body := get(t, client, server.URL+"/local"); body != host+" admin secret abc" {
+		t.Errorf("unexpected body %q", body)
+	}
+	otherHost := other.Listener.Addr().String()
+	if body := get(t, client, server.URL+"/remote"); body != otherHost+"   " {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestNewWithTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Client certificate, also used as the CA the server trusts
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, "client.key"), "EC PRIVATE KEY", keyDer)
+	clientCert, _ := x509.ParseCertificate(der)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", server.TLS.Certificates[0].Certificate[0])
+
+	// The server certificate isn't trusted by default
+	client, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Get(server.URL); err == nil {
+		t.Error()
+	}
+
+	// The client certificate is required
+	client, err = New(Options{CABundle: filepath.Join(dir, "ca.pem")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Get(server.URL); err == nil {
+		t.Error()
+	}
+
+	client, err = New(Options{
+		CABundle:   filepath.Join(dir, "ca.pem"),
+		ClientCert: filepath.Join(dir, "client.pem"),
+		ClientKey:  filepath.Join(dir, "client.key"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, client, server.URL)
+
+	client, err = New(Options{
+		InsecureSkipVerify: true,
+		ClientCert:         filepath.Join(dir, "client.pem"),
+		ClientKey:          filepath.Join(dir, "client.key"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, client, server.URL)
+
+	if _, err = New(Options{ClientCert: filepath.Join(dir, "client.pem")}); err == nil {
+		t.Error()
+	}
+	if _, err = New(Options{CABundle: filepath.Join(dir, "client.key")}); err == nil {
+		t.Error()
+	}
+}
+
+func TestNewWithUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "status.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client, err := New(Options{UnixSocket: socket, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := get(t, client, "http://localhost/status"); body != "localhost   " {
+		t.Error()
+	}
+	if client.Timeout != 5*time.Second {
+		t.Error()
+	}
+}
+
+func TestHeadersFromJSON(t *testing.T) {
+	headers, err := HeadersFromJSON(map[string]interface{}{"X-Token": "abc"})
+	if err != nil || headers["X-Token"] != "abc" {
+		t.Error()
+	}
+	if headers, err = HeadersFromJSON(nil); err != nil || len(headers) != 0 {
+		t.Error()
+	}
+	if _, err = HeadersFromJSON([]interface{}{"X-Token"}); err == nil {
+		t.Error()
+	}
+	if _, err = HeadersFromJSON(map[string]interface{}{"X-Retries": 3.0}); err == nil {
+		t.Error()
+	}
+}
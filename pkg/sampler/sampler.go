@@ -0,0 +1,69 @@
+// Package sampler turns counters into RATE and DELTA values the way the SDK
+// does, but under keys chosen by the integration. The SDK caches previous
+// values by metric name only, so samples of the same event type sharing
+// metric names, like the pools, zones or instances of a run, would overwrite
+// each other's previous value.
+package sampler
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/cache"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+)
+
+// lock guards the SDK cache, which isn't safe for concurrent use
+var lock sync.Mutex
+
+// Key returns the cache key of a metric in a namespace, the metric name
+// alone when the namespace is empty.
+func Key(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// Sample returns the RATE or DELTA value of a counter against the value
+// cached under key, and caches the new one. The first sample of a key is 0.
+// It can be called from several goroutines.
+func Sample(key string, value interface{}, sourceType metric.SourceType) (float64, error) {
+	floatValue, err := parseValue(key, value)
+	if err != nil {
+		return 0, err
+	}
+
+	lock.Lock()
+	oldValue, oldTime, ok := cache.Get(key)
+	newTime := cache.Set(key, floatValue)
+	lock.Unlock()
+	if !ok {
+		return 0, nil
+	}
+	return compare(key, oldValue, oldTime, floatValue, newTime, sourceType)
+}
+
+func parseValue(key string, value interface{}) (float64, error) {
+	floatValue, err := strconv.ParseFloat(fmt.Sprintf("%v", value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Can't sample metric of unknown type %s", key)
+	}
+	return floatValue, nil
+}
+
+// compare returns the RATE or DELTA value of a counter between two samples
+func compare(key string, oldValue float64, oldTime int64, newValue float64, newTime int64, sourceType metric.SourceType) (float64, error) {
+	duration := newTime - oldTime
+	if duration == 0 {
+		return 0, fmt.Errorf("Samples for %s are too close in time, skipping sampling", key)
+	}
+	if newValue < oldValue {
+		return 0, fmt.Errorf("Source for %s was reseted, skipping sampling", key)
+	}
+	if sourceType == metric.DELTA {
+		return newValue - oldValue, nil
+	}
+	return (newValue - oldValue) / float64(duration), nil
+}
@@ -0,0 +1,64 @@
+package sampler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/cache"
+	"github.com/newrelic/infra-integrations-sdk/metric"
+)
+
+func TestKey(t *testing.T) {
+	if Key("", "net.requestsPerSecond") != "net.requestsPerSecond" {
+		t.Error()
+	}
+	if Key("pool/www", "net.requestsPerSecond") != "pool/www/net.requestsPerSecond" {
+		t.Error()
+	}
+}
+
+func TestSample(t *testing.T) {
+	now := time.Now()
+	cache.SetNow(func() time.Time { return now })
+	defer cache.SetNow(time.Now)
+
+	// The first sample of a key has nothing to compare with
+	if value, err := Sample("test/rate", 100, metric.RATE); err != nil || value != 0 {
+		t.Error()
+	}
+	if value, err := Sample("test/delta", "100", metric.DELTA); err != nil || value != 0 {
+		t.Error()
+	}
+	if _, err := Sample("test/delta", 150, metric.DELTA); err == nil {
+		t.Error("expected an error for samples at the same time")
+	}
+
+	now = now.Add(10 * time.Second)
+	if value, err := Sample("test/rate", 200, metric.RATE); err != nil || value != 10 {
+		t.Errorf("expected a rate of 10, got %v (%v)", value, err)
+	}
+	if value, err := Sample("test/delta", 180, metric.DELTA); err != nil || value != 30 {
+		t.Errorf("expected a delta of 30, got %v (%v)", value, err)
+	}
+
+	now = now.Add(10 * time.Second)
+	if _, err := Sample("test/rate", 50, metric.RATE); err == nil {
+		t.Error("expected an error for a reset counter")
+	}
+	if _, err := Sample("test/rate", "unknown", metric.RATE); err == nil {
+		t.Error("expected an error for a non numeric value")
+	}
+}
+
+func TestSampleConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			Sample(Key("instance", string(rune('a'+i))), i, metric.RATE)
+		}(i)
+	}
+	wg.Wait()
+}
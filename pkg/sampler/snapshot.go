@@ -0,0 +1,103 @@
+package sampler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/metric"
+)
+
+var now = time.Now
+
+// SetNow forces a different current time for the snapshots. It's useful
+// only for unit testing.
+func SetNow(newNow func() time.Time) {
+	now = newNow
+}
+
+// snapshotsLock guards the snapshots file, shared by the snapshots of a run
+var snapshotsLock sync.Mutex
+
+// Snapshot samples counters like Sample, but against the values of the
+// previous run kept apart from the SDK cache, which can't forget keys. A
+// snapshot is saved as a whole, so the counters that are no longer sampled,
+// like the ones of evicted statement digests, are dropped.
+type Snapshot struct {
+	name     string
+	previous snapshotData
+	current  snapshotData
+}
+
+type snapshotData struct {
+	Time   int64              `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+// snapshotsPath returns the file the snapshots are kept in, next to the SDK
+// cache.
+func snapshotsPath() string {
+	path := os.Getenv("NRIA_CACHE_PATH")
+	if path == "" {
+		path = filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+".json")
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "-snapshots.json"
+}
+
+// readSnapshots returns every saved snapshot, none if the file can't be read
+func readSnapshots() map[string]snapshotData {
+	snapshots := make(map[string]snapshotData)
+	if content, err := ioutil.ReadFile(snapshotsPath()); err == nil {
+		json.Unmarshal(content, &snapshots)
+	}
+	return snapshots
+}
+
+// LoadSnapshot returns the snapshot saved under name by the previous run. A
+// snapshot from the future, as after the clock went back, is ignored.
+func LoadSnapshot(name string) *Snapshot {
+	snapshotsLock.Lock()
+	previous, ok := readSnapshots()[name]
+	snapshotsLock.Unlock()
+
+	current := snapshotData{Time: now().Unix(), Values: make(map[string]float64)}
+	if !ok || previous.Time > current.Time {
+		previous = snapshotData{}
+	}
+	return &Snapshot{name: name, previous: previous, current: current}
+}
+
+// Sample returns the RATE or DELTA value of a counter against the previous
+// snapshot and records the new value. The first sample of a key is 0.
+func (s *Snapshot) Sample(key string, value interface{}, sourceType metric.SourceType) (float64, error) {
+	floatValue, err := parseValue(key, value)
+	if err != nil {
+		return 0, err
+	}
+	s.current.Values[key] = floatValue
+
+	oldValue, ok := s.previous.Values[key]
+	if !ok {
+		return 0, nil
+	}
+	return compare(key, oldValue, s.previous.Time, floatValue, s.current.Time, sourceType)
+}
+
+// Save replaces the previous snapshot by the values sampled since it was
+// loaded. It can be called from several goroutines.
+func (s *Snapshot) Save() error {
+	snapshotsLock.Lock()
+	defer snapshotsLock.Unlock()
+
+	snapshots := readSnapshots()
+	snapshots[s.name] = s.current
+	content, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(snapshotsPath(), content, 0644)
+}
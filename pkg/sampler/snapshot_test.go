@@ -0,0 +1,91 @@
+package sampler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/metric"
+)
+
+// useTempSnapshots keeps the snapshots of a test in a directory of its own
+func useTempSnapshots(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "sampler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("NRIA_CACHE_PATH")
+	os.Setenv("NRIA_CACHE_PATH", filepath.Join(dir, "cache.json"))
+	return func() {
+		os.Setenv("NRIA_CACHE_PATH", oldPath)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	defer useTempSnapshots(t)()
+	now := time.Now()
+	SetNow(func() time.Time { return now })
+	defer SetNow(time.Now)
+
+	snapshot := LoadSnapshot("digests")
+	if value, err := snapshot.Sample("orders", 100, metric.DELTA); err != nil || value != 0 {
+		t.Error()
+	}
+	snapshot.Sample("users", 10, metric.DELTA)
+	if err := snapshot.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// Other snapshots are left untouched
+	other := LoadSnapshot("other")
+	other.Sample("orders", 1, metric.DELTA)
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(10 * time.Second)
+	snapshot = LoadSnapshot("digests")
+	if value, err := snapshot.Sample("orders", 150, metric.DELTA); err != nil || value != 50 {
+		t.Errorf("expected a delta of 50, got %v (%v)", value, err)
+	}
+	if value, err := snapshot.Sample("orders/rate", 150, metric.RATE); err != nil || value != 0 {
+		t.Error()
+	}
+	if err := snapshot.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// users wasn't sampled by the previous run, so it's forgotten
+	now = now.Add(10 * time.Second)
+	snapshot = LoadSnapshot("digests")
+	if value, err := snapshot.Sample("users", 20, metric.DELTA); err != nil || value != 0 {
+		t.Error()
+	}
+	if value, err := snapshot.Sample("orders/rate", 250, metric.RATE); err != nil || value != 10 {
+		t.Errorf("expected a rate of 10, got %v (%v)", value, err)
+	}
+	if _, err := snapshot.Sample("orders", 10, metric.DELTA); err == nil {
+		t.Error("expected an error for a reset counter")
+	}
+	if value, err := LoadSnapshot("other").Sample("orders", 3, metric.DELTA); err != nil || value != 2 {
+		t.Error()
+	}
+}
+
+func TestSnapshotFromTheFuture(t *testing.T) {
+	defer useTempSnapshots(t)()
+	now := time.Now()
+	SetNow(func() time.Time { return now })
+	defer SetNow(time.Now)
+
+	snapshot := LoadSnapshot("digests")
+	snapshot.Sample("orders", 100, metric.DELTA)
+	snapshot.Save()
+
+	now = now.Add(-time.Minute)
+	if value, err := LoadSnapshot("digests").Sample("orders", 150, metric.DELTA); err != nil || value != 0 {
+		t.Error()
+	}
+}